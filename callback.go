@@ -0,0 +1,272 @@
+package spider
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/xpath"
+	"github.com/cihub/seelog"
+)
+
+//一次响应中所有回调共享, 回调在不同页面之间并发执行
+type CallbackContext struct {
+	Request  *CrawlRequest
+	Response *http.Response
+	Body     []byte
+
+	reqs  []*CrawlRequest
+	items []Item
+	mutex sync.Mutex
+}
+
+//相对于当前页面解析, 返回的请求可以继续设置Meta和Priority
+func (ctx *CallbackContext) Visit(link string) (*CrawlRequest, error) {
+	u, err := ctx.Response.Request.URL.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	setReferer(req, ctx.Response.Request.URL.String())
+	return ctx.Enqueue(req), nil
+}
+
+func (ctx *CallbackContext) Enqueue(req *http.Request) *CrawlRequest {
+	child := ctx.Request.Child(req, SourceCallback)
+	ctx.mutex.Lock()
+	ctx.reqs = append(ctx.reqs, child)
+	ctx.mutex.Unlock()
+	return child
+}
+
+//Item附加到Result并进入ItemPipeline
+func (ctx *CallbackContext) Emit(item Item) {
+	ctx.mutex.Lock()
+	ctx.items = append(ctx.items, item)
+	ctx.mutex.Unlock()
+}
+
+func (ctx *CallbackContext) AbsoluteURL(link string) string {
+	u, err := ctx.Response.Request.URL.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+type HTMLElement struct {
+	Name  string
+	Text  string
+	Index int //在所有匹配中的序号
+	DOM   *goquery.Selection
+}
+
+func (e *HTMLElement) Attr(name string) string {
+	return e.DOM.AttrOr(name, "")
+}
+
+func (e *HTMLElement) ChildText(selector string) string {
+	return strings.TrimSpace(e.DOM.Find(selector).Text())
+}
+
+func (e *HTMLElement) ChildAttr(selector, name string) string {
+	return strings.TrimSpace(e.DOM.Find(selector).AttrOr(name, ""))
+}
+
+func (e *HTMLElement) ChildAttrs(selector, name string) []string {
+	var values []string
+	e.DOM.Find(selector).Each(func(i int, s *goquery.Selection) {
+		if value, ok := s.Attr(name); ok {
+			values = append(values, strings.TrimSpace(value))
+		}
+	})
+	return values
+}
+
+//XPath匹配的节点, 子查询同样使用XPath, xml响应按xml文档求值
+type XMLElement struct {
+	Name string
+	Text string
+	nav  xpath.NodeNavigator
+}
+
+func newXMLElement(nav xpath.NodeNavigator) *XMLElement {
+	return &XMLElement{Name: nav.LocalName(), Text: strings.TrimSpace(nav.Value()), nav: nav.Copy()}
+}
+
+func (e *XMLElement) Attr(name string) string {
+	value, _ := xpathAttr(e.nav, name)
+	return value
+}
+
+func (e *XMLElement) ChildText(expr string) string {
+	values := e.ChildTexts(expr)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (e *XMLElement) ChildTexts(expr string) []string {
+	compiled, err := xpath.Compile(expr)
+	if err != nil {
+		seelog.Errorf("XMLElement::ChildTexts | compile %s err: %s", expr, err)
+		return nil
+	}
+	values := xpathValues(e.nav, compiled)
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+type htmlCallback struct {
+	selector string
+	fn       func(*HTMLElement, *CallbackContext)
+}
+
+type xmlCallback struct {
+	expr string
+	fn   func(*XMLElement, *CallbackContext)
+}
+
+//按注册顺序执行: OnResponse, OnHTML, OnXML, OnScraped
+//html和xml回调只作用于html和xml响应
+type CallbackProcesser struct {
+	responses []func(*CallbackContext)
+	htmls     []htmlCallback
+	xmls      []xmlCallback
+	scrapeds  []func(*CallbackContext)
+	mutex     sync.RWMutex
+}
+
+func NewCallbackProcesser() *CallbackProcesser {
+	return &CallbackProcesser{}
+}
+
+func (cp *CallbackProcesser) OnResponse(fn func(ctx *CallbackContext)) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.responses = append(cp.responses, fn)
+}
+
+func (cp *CallbackProcesser) OnHTML(selector string, fn func(e *HTMLElement, ctx *CallbackContext)) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.htmls = append(cp.htmls, htmlCallback{selector: selector, fn: fn})
+}
+
+func (cp *CallbackProcesser) OnXML(expr string, fn func(e *XMLElement, ctx *CallbackContext)) error {
+	if _, err := xpath.Compile(expr); err != nil {
+		return err
+	}
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.xmls = append(cp.xmls, xmlCallback{expr: expr, fn: fn})
+	return nil
+}
+
+//所有回调执行完之后
+func (cp *CallbackProcesser) OnScraped(fn func(ctx *CallbackContext)) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.scrapeds = append(cp.scrapeds, fn)
+}
+
+func (cp *CallbackProcesser) active() bool {
+	cp.mutex.RLock()
+	defer cp.mutex.RUnlock()
+	return len(cp.responses)+len(cp.htmls)+len(cp.xmls)+len(cp.scrapeds) > 0
+}
+
+func (cp *CallbackProcesser) Finish() {
+	return
+}
+
+func (cp *CallbackProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, error) {
+	reqs, _, err := cp.ProcessItems(charSet, certain, rsp)
+	return reqs, err
+}
+
+func (cp *CallbackProcesser) ProcessItems(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, []Item, error) {
+	defer rsp.Body.Close()
+
+	cp.mutex.RLock()
+	responses, htmls, xmls, scrapeds := cp.responses, cp.htmls, cp.xmls, cp.scrapeds
+	cp.mutex.RUnlock()
+
+	markup := markupSuffix(ResponseSuffix(rsp)) && len(htmls)+len(xmls) > 0
+	var body []byte
+	var err error
+	if markup {
+		var utfReader io.Reader
+		utfReader, err = utf8Reader(rsp, charSet, certain)
+		if err != nil {
+			seelog.Errorf("CallbackProcesser::ProcessItems | utf8 reader charset: %s, err: %s", charSet, err)
+			return nil, nil, err
+		}
+		body, err = ioutil.ReadAll(utfReader)
+	} else {
+		body, err = ioutil.ReadAll(rsp.Body)
+	}
+	if err != nil {
+		seelog.Errorf("CallbackProcesser::ProcessItems | read all err: %s", err)
+		return nil, nil, err
+	}
+
+	ctx := &CallbackContext{Request: CrawlRequestOf(rsp), Response: rsp, Body: body}
+	for _, fn := range responses {
+		fn(ctx)
+	}
+
+	if markup {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if err != nil {
+			seelog.Errorf("CallbackProcesser::ProcessItems | new document err: %s", err)
+			return nil, nil, err
+		}
+		for _, callback := range htmls {
+			doc.Find(callback.selector).Each(func(i int, s *goquery.Selection) {
+				callback.fn(&HTMLElement{
+					Name:  goquery.NodeName(s),
+					Text:  strings.TrimSpace(s.Text()),
+					Index: i,
+					DOM:   s,
+				}, ctx)
+			})
+		}
+		var root xpath.NodeNavigator
+		if len(xmls) > 0 {
+			root = xpathRoot(rsp, body, doc)
+		}
+		exprs := xpathExprs{}
+		for _, callback := range xmls {
+			expr, err := exprs.compile(callback.expr)
+			if err != nil {
+				continue
+			}
+			iter := expr.Select(root.Copy())
+			for iter.MoveNext() {
+				callback.fn(newXMLElement(iter.Current()), ctx)
+			}
+		}
+	}
+
+	for _, fn := range scrapeds {
+		fn(ctx)
+	}
+	return ctx.reqs, ctx.items, nil
+}
+
+//text/html、application/xml以及+xml等类型
+func markupSuffix(suffix string) bool {
+	mediaType, ok := DefaultMimeRegistry.MediaType(suffix)
+	return ok && (strings.Contains(mediaType, "html") || strings.Contains(mediaType, "xml"))
+}
@@ -0,0 +1,107 @@
+package spider
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+//go test -v -run=Test_SpiderCallbacks
+func Test_SpiderCallbacks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body>
+<div class="post"><h2>first</h2><a href="/about.html">about</a></div>
+<div class="post"><h2>second</h2></div>
+<span data-next="/page/2"></span>
+</body></html>`)
+		case "/page/2":
+			fmt.Fprint(w, `<html><body><div class="post"><h2>third</h2></div></body></html>`)
+		default:
+			fmt.Fprint(w, `<html><body>about</body></html>`)
+		}
+	}))
+	defer server.Close()
+
+	var titles, nexts []string
+	var scraped int
+	var mutex sync.Mutex
+	spider := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+	)
+	spider.OnHTML("div.post", func(e *HTMLElement, ctx *CallbackContext) {
+		ctx.Emit(Item{"title": e.ChildText("h2"), "page": ctx.Request.Meta["page"]})
+	}).OnXML("//span[@data-next]", func(e *XMLElement, ctx *CallbackContext) {
+		next, err := ctx.Visit(e.Attr("data-next"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		next.Meta["page"] = 2
+		mutex.Lock()
+		nexts = append(nexts, next.URL.String())
+		mutex.Unlock()
+	}).OnScraped(func(ctx *CallbackContext) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		scraped++
+		for _, item := range ctx.items {
+			titles = append(titles, item["title"].(string))
+		}
+	})
+	seed, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	result := spider.AddRequest(seed).Run().Result()
+
+	if len(nexts) != 1 || nexts[0] != server.URL+"/page/2" {
+		t.Errorf("unexpected next pages %v", nexts)
+	}
+	page2 := result[server.URL+"/page/2"]
+	if page2 == nil || page2.Source != SourceCallback || len(page2.Items) != 1 || page2.Items[0]["page"] != 2 {
+		t.Errorf("unexpected page 2 result %+v", page2)
+	}
+	if len(result[server.URL+"/"].Items) != 2 || len(titles) != 3 {
+		t.Errorf("unexpected titles %v", titles)
+	}
+	//DomProcesser同时跟进链接
+	if about := result[server.URL+"/about.html"]; about == nil || about.Source != SourceLink {
+		t.Errorf("dom links should still be followed, got %+v", about)
+	}
+	if scraped != 3 {
+		t.Errorf("expected 3 scraped pages, got %d", scraped)
+	}
+}
+
+type errorReader struct{}
+
+func (er errorReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+//go test -v -run=Test_CallbackProcesserMarkup
+func Test_CallbackProcesserMarkup(t *testing.T) {
+	cp := NewCallbackProcesser()
+	var titles []string
+	cp.OnHTML("h1", func(e *HTMLElement, ctx *CallbackContext) {
+		titles = append(titles, e.Text)
+	})
+
+	//Content-Type不准确时按嗅探的后缀判断
+	rsp := newTestResponse(t, "http://example.com/", "text/plain", []byte(`<html><body><h1>title</h1></body></html>`))
+	bindResponseSuffix(rsp, ContentTypeHTML)
+	if _, _, err := cp.ProcessItems("utf-8", true, rsp); err != nil || len(titles) != 1 {
+		t.Errorf("sniffed html should trigger OnHTML, got %v, %v", titles, err)
+	}
+
+	rsp = newTestResponse(t, "http://example.com/", "text/html", nil)
+	rsp.Body = ioutil.NopCloser(errorReader{})
+	if _, _, err := cp.ProcessItems("utf-8", true, rsp); err == nil {
+		t.Errorf("read errors of markup responses should be returned")
+	}
+}
@@ -0,0 +1,107 @@
+package spider
+
+import (
+	"context"
+	"net/http"
+)
+
+//请求的来源
+const (
+	SourceSeed       = "seed"
+	SourceLink       = "link"
+	SourceStyle      = "style"
+	SourceScript     = "script"
+	SourceForm       = "form"
+	SourceFeed       = "feed"
+	SourceApi        = "api"
+	SourcePagination = "pagination"
+	SourceCallback   = "callback"
+)
+
+//在Scheduler、Processer和Result之间传递的请求, Meta由使用者自定义
+type CrawlRequest struct {
+	*http.Request
+
+	Depth    uint
+	Parent   string //发现该请求的页面url, 种子请求为空
+	Priority uint
+	Retry    uint
+	Source   string
+	Link     *Link //发现该请求的链接, 种子请求为nil
+	Meta     map[string]interface{}
+}
+
+type crawlRequestKey struct{}
+
+type responseSuffixKey struct{}
+
+//种子请求
+func NewCrawlRequest(req *http.Request) *CrawlRequest {
+	return &CrawlRequest{
+		Request:  req,
+		Priority: PriorityNormal,
+		Source:   SourceSeed,
+		Meta:     make(map[string]interface{}),
+	}
+}
+
+//子请求不继承Meta, 需要传给子请求的数据由Processer显式设置
+func (cr *CrawlRequest) Child(req *http.Request, source string) *CrawlRequest {
+	return &CrawlRequest{
+		Request:  req,
+		Depth:    cr.Depth + 1,
+		Parent:   cr.URL.String(),
+		Priority: PriorityNormal,
+		Source:   source,
+		Meta:     make(map[string]interface{}),
+	}
+}
+
+func (cr *CrawlRequest) Children(reqs []*http.Request, source string) []*CrawlRequest {
+	children := make([]*CrawlRequest, 0, len(reqs))
+	for _, req := range reqs {
+		children = append(children, cr.Child(req, source))
+	}
+	return children
+}
+
+//重试时body需要重新获取
+func (cr *CrawlRequest) retry() (*CrawlRequest, error) {
+	req := cr.Request.Clone(cr.Context())
+	if cr.GetBody != nil {
+		body, err := cr.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+	retried := *cr
+	retried.Request = req
+	retried.Retry++
+	return &retried, nil
+}
+
+//Processer通过响应取得对应的CrawlRequest, 不是由Spider发出的响应视为种子请求
+func CrawlRequestOf(rsp *http.Response) *CrawlRequest {
+	if cr, ok := rsp.Request.Context().Value(crawlRequestKey{}).(*CrawlRequest); ok {
+		return cr
+	}
+	return NewCrawlRequest(rsp.Request)
+}
+
+//Spider根据Content-Type和内容嗅探确定的后缀, 不是由Spider发出的响应只根据Content-Type判断
+func ResponseSuffix(rsp *http.Response) string {
+	if suffix, ok := rsp.Request.Context().Value(responseSuffixKey{}).(string); ok {
+		return suffix
+	}
+	suffix, _ := httpHeaderContentType(DefaultMimeRegistry, rsp.Header.Get("Content-Type"))
+	return suffix
+}
+
+func bindResponseSuffix(rsp *http.Response, suffix string) {
+	rsp.Request = rsp.Request.WithContext(context.WithValue(rsp.Request.Context(), responseSuffixKey{}, suffix))
+}
+
+func (cr *CrawlRequest) bind() {
+	cr.Request = cr.Request.WithContext(context.WithValue(cr.Context(), crawlRequestKey{}, cr))
+}
@@ -0,0 +1,100 @@
+package spider
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//把父请求Meta中的trail加上当前路径传给子请求
+type trailProcesser struct {
+	trails map[string]string
+	mutex  sync.Mutex
+}
+
+func (tp *trailProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, error) {
+	defer rsp.Body.Close()
+
+	parent := CrawlRequestOf(rsp)
+	trail, _ := parent.Meta["trail"].(string)
+	trail += rsp.Request.URL.Path
+	tp.mutex.Lock()
+	tp.trails[rsp.Request.URL.Path] = trail
+	tp.mutex.Unlock()
+
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []*CrawlRequest
+	for _, path := range strings.Fields(string(data)) {
+		req, err := rsp.Request.URL.Parse(path)
+		if err != nil {
+			continue
+		}
+		httpReq, _ := http.NewRequest(http.MethodGet, req.String(), nil)
+		child := parent.Child(httpReq, "trail")
+		child.Meta["trail"] = trail
+		reqs = append(reqs, child)
+	}
+	return reqs, nil
+}
+
+func (tp *trailProcesser) Finish() {}
+
+//go test -v -run=Test_CrawlRequest
+func Test_CrawlRequest(t *testing.T) {
+	var posts []string
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, "/a")
+		case "/a":
+			fmt.Fprint(w, "/b")
+		case "/b":
+			fmt.Fprint(w, " ")
+		case "/post":
+			body, _ := ioutil.ReadAll(r.Body)
+			mutex.Lock()
+			posts = append(posts, string(body))
+			retry := len(posts) < 2
+			mutex.Unlock()
+			if retry {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}
+	}))
+	defer server.Close()
+
+	tp := &trailProcesser{trails: map[string]string{}}
+	router := NewProcesserRouter()
+	router.Handle([]Processer{tp}, OptionProcesserRouteSuffixs(ContentTypeTXT))
+	spider := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+		OptionSpiderProcesserRouter(router),
+	)
+	seed, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	post, _ := http.NewRequest(http.MethodPost, server.URL+"/post", strings.NewReader("k=v"))
+	cr := NewCrawlRequest(post)
+	cr.Priority = PriorityHigh
+	result := spider.AddRequest(seed).AddCrawlRequest(cr).Run().Result()
+
+	b := result[server.URL+"/b"]
+	if b == nil || b.Depth != 2 || b.Parent != server.URL+"/a" || b.Source != "trail" || b.Req.Meta["trail"] != "//a" {
+		t.Errorf("unexpected result: %+v", b)
+	}
+	if tp.trails["/b"] != "//a/b" {
+		t.Errorf("meta should pass to children, got %v", tp.trails)
+	}
+	//重试的POST带有原来的body
+	if len(posts) != 2 || posts[0] != "k=v" || posts[1] != "k=v" {
+		t.Errorf("unexpected posts: %v", posts)
+	}
+}
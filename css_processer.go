@@ -0,0 +1,211 @@
+package spider
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cihub/seelog"
+)
+
+//解析样式表中的url(...)和@import
+type CssProcesser struct{}
+
+func NewCssProcesser() *CssProcesser {
+	return &CssProcesser{}
+}
+
+func (cp *CssProcesser) Finish() {
+	return
+}
+
+func (cp *CssProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, error) {
+	defer rsp.Body.Close()
+
+	utfReader, err := utf8Reader(rsp, charSet, certain)
+	if err != nil {
+		seelog.Errorf("CssProcesser::Process | utf8 reader charset: %s, err: %s", charSet, err)
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(utfReader)
+	if err != nil {
+		seelog.Errorf("CssProcesser::Process | read all err: %s", err)
+		return nil, err
+	}
+
+	//样式表中的相对路径相对于样式表本身
+	base := rsp.Request.URL.String()
+	links := mergeUrls(base, ExtractCssLinks(string(data)))
+	return CrawlRequestOf(rsp).Children(referRequests(base, links), SourceStyle), nil
+}
+
+func referRequests(refer string, links []string) []*http.Request {
+	var reqs []*http.Request
+	for _, link := range links {
+		req, err := http.NewRequest(http.MethodGet, link, nil)
+		if err != nil {
+			continue
+		}
+		setReferer(req, refer)
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+func mergeUrls(base string, subs []string) []string {
+	var links []string
+	for _, sub := range subs {
+		if u := mergeUrl(base, sub); u != "" {
+			links = append(links, u)
+		}
+	}
+	return links
+}
+
+//https://www.w3.org/TR/css-syntax-3/#consume-url-token
+func ExtractCssLinks(css string) []string {
+	var links []string
+	for i := 0; i < len(css); {
+		switch {
+		case strings.HasPrefix(css[i:], "/*"):
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return links
+			}
+			i += end + 4
+
+		case css[i] == '"' || css[i] == '\'':
+			//普通字符串, 比如content: "url(x)"
+			_, i = cssString(css, i)
+
+		case css[i] == '\\':
+			i += 2
+
+		case cssHasPrefixFold(css[i:], "url(") && (i == 0 || !cssIdent(css[i-1])):
+			var link string
+			link, i = cssUrl(css, i+len("url("))
+			if link != "" {
+				links = append(links, link)
+			}
+
+		case cssHasPrefixFold(css[i:], "@import"):
+			i += len("@import")
+			for i < len(css) && cssSpace(css[i]) {
+				i++
+			}
+			//@import url(...)由下一轮处理
+			if i < len(css) && (css[i] == '"' || css[i] == '\'') {
+				var link string
+				link, i = cssString(css, i)
+				if link != "" {
+					links = append(links, link)
+				}
+			}
+
+		default:
+			i++
+		}
+	}
+	return links
+}
+
+//start指向引号, 返回反转义后的内容和结束引号之后的位置
+func cssString(css string, start int) (string, int) {
+	quote := css[start]
+	var builder strings.Builder
+	i := start + 1
+	for i < len(css) {
+		switch c := css[i]; {
+		case c == quote:
+			return builder.String(), i + 1
+		case c == '\n':
+			//未闭合的字符串
+			return "", i
+		case c == '\\':
+			var r string
+			r, i = cssEscape(css, i)
+			builder.WriteString(r)
+		default:
+			builder.WriteByte(c)
+			i++
+		}
+	}
+	return "", i
+}
+
+//start指向url(之后
+func cssUrl(css string, start int) (string, int) {
+	i := start
+	for i < len(css) && cssSpace(css[i]) {
+		i++
+	}
+	if i >= len(css) {
+		return "", i
+	}
+
+	var link string
+	if css[i] == '"' || css[i] == '\'' {
+		link, i = cssString(css, i)
+	} else {
+		var builder strings.Builder
+		for i < len(css) && css[i] != ')' && !cssSpace(css[i]) {
+			if css[i] == '\\' {
+				var r string
+				r, i = cssEscape(css, i)
+				builder.WriteString(r)
+				continue
+			}
+			builder.WriteByte(css[i])
+			i++
+		}
+		link = builder.String()
+	}
+
+	for i < len(css) && css[i] != ')' {
+		i++
+	}
+	return strings.TrimSpace(link), i + 1
+}
+
+//start指向反斜杠
+func cssEscape(css string, start int) (string, int) {
+	i := start + 1
+	if i >= len(css) {
+		return "", i
+	}
+	if css[i] == '\n' {
+		return "", i + 1
+	}
+
+	end := i
+	for end < len(css) && end-i < 6 && strings.IndexByte("0123456789abcdefABCDEF", css[end]) >= 0 {
+		end++
+	}
+	if end == i {
+		_, size := utf8.DecodeRuneInString(css[i:])
+		return css[i : i+size], i + size
+	}
+	code, _ := strconv.ParseUint(css[i:end], 16, 32)
+	if end < len(css) && cssSpace(css[end]) {
+		end++
+	}
+	if code == 0 || code > utf8.MaxRune {
+		return string(utf8.RuneError), end
+	}
+	return string(rune(code)), end
+}
+
+func cssHasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func cssSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func cssIdent(c byte) bool {
+	return c == '-' || c == '_' || c >= utf8.RuneSelf ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,69 @@
+package spider
+
+import (
+	"reflect"
+	"testing"
+)
+
+//go test -v -run=Test_ExtractCssLinks
+func Test_ExtractCssLinks(t *testing.T) {
+	css := `@charset "utf-8";
+@import "base.css";
+@import url('print.css') print;
+/* background: url(commented.png); */
+body { background: #fff URL( "img/bg.png" ) no-repeat; }
+.icon { background-image: url(img/icon\ 1.png), url(data:image/png;base64,AAAA); }
+.x::before { content: "url(not-a-link.png)"; }
+@font-face { font-family: F; src: url(fonts/f.woff2) format("woff2"), url('fonts/f.woff') format("woff"); }
+.y { mask: my-url(nope.png); background: url(\2f abs.png); }`
+
+	expected := []string{
+		"base.css",
+		"print.css",
+		"img/bg.png",
+		"img/icon 1.png",
+		"data:image/png;base64,AAAA",
+		"fonts/f.woff2",
+		"fonts/f.woff",
+		"/abs.png",
+	}
+	if links := ExtractCssLinks(css); !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %v, got %v", expected, links)
+	}
+}
+
+//go test -v -run=Test_CssProcesser
+func Test_CssProcesser(t *testing.T) {
+	rsp := newTestResponse(t, "http://example.com/static/css/site.css", "text/css",
+		[]byte(`@import "../reset.css"; body { background: url(/img/bg.png) } .a { background: url(http://other.com/x.png) }`))
+	reqs, err := NewCssProcesser().Process("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var links []string
+	for _, req := range reqs {
+		links = append(links, req.URL.String())
+	}
+	expected := []string{"http://example.com/static/reset.css", "http://example.com/img/bg.png"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %v, got %v", expected, links)
+	}
+
+	page := `<html><head><style>.a { background: url(a.png) }</style></head>
+<body><div style="background-image: url('/b.png')"></div></body></html>`
+	rsp = newTestResponse(t, "http://example.com/dir/", "text/html", []byte(page))
+	reqs, err = NewDomProcesser().Process("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	links = nil
+	for _, req := range reqs {
+		links = append(links, req.URL.String())
+	}
+	expected = []string{"http://example.com/dir/a.png", "http://example.com/b.png"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %v, got %v", expected, links)
+	}
+}
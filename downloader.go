@@ -1,6 +1,7 @@
 package spider
 
 import (
+	"bufio"
 	"cpf_server/library/cpf_common/common"
 	"crypto/md5"
 	"encoding/hex"
@@ -10,20 +11,38 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+
+	"golang.org/x/net/html/charset"
 )
 
 type Downloader interface {
 	//url, header, reader, suffix
 	//url location, header location, body location
 	Download(*url.URL, http.Header, io.Reader, string) (*string, *string, *string, error)
+	//删除Download返回的内容, 比如超过大小限制被截断的文件
+	Remove(*string, *string, *string) error
+}
+
+type OptionFileDownloader func(*FileDownloader)
+
+//文本类型额外保存一份UTF-8编码的副本, 路径为body路径加.utf8
+func OptionFileDownloaderUTF8Copy(enabled bool) OptionFileDownloader {
+	return func(fd *FileDownloader) {
+		fd.utf8Copy = enabled
+	}
 }
 
 type FileDownloader struct {
-	path string
+	path     string
+	utf8Copy bool
 }
 
-func NewFileDownloader(path string) *FileDownloader {
-	return &FileDownloader{path: path}
+func NewFileDownloader(path string, options ...OptionFileDownloader) *FileDownloader {
+	fd := &FileDownloader{path: path}
+	for _, option := range options {
+		option(fd)
+	}
+	return fd
 }
 
 func (fd *FileDownloader) Download(u *url.URL, header http.Header, reader io.Reader, suffix string) (*string, *string, *string, error) {
@@ -48,9 +67,72 @@ func (fd *FileDownloader) Download(u *url.URL, header http.Header, reader io.Rea
 		return nil, nil, nil, err
 	}
 
+	if fd.utf8Copy && textSuffixs[suffix] {
+		if err := writeUTF8Copy(header, bodyPath, fmt.Sprintf("%s.%s", bodyPath, "utf8")); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
 	return &urlPath, &headerPath, &bodyPath, nil
 }
 
+func (fd *FileDownloader) Remove(urlPath, headerPath, bodyPath *string) error {
+	var utf8Path *string
+	if bodyPath != nil {
+		path := fmt.Sprintf("%s.%s", *bodyPath, "utf8")
+		utf8Path = &path
+	}
+	for _, path := range []*string{urlPath, headerPath, bodyPath, utf8Path} {
+		if path == nil {
+			continue
+		}
+		if err := os.Remove(*path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+//需要转码的文本类型
+var textSuffixs = map[string]bool{
+	ContentTypeCSS:   true,
+	ContentTypeCSV:   true,
+	ContentTypeHTM:   true,
+	ContentTypeHTML:  true,
+	ContentTypeJS:    true,
+	ContentTypeJSON:  true,
+	ContentTypeMJS:   true,
+	ContentTypeTXT:   true,
+	ContentTypeXHTML: true,
+	ContentTypeXML:   true,
+}
+
+//源文件已是UTF-8时不生成副本
+func writeUTF8Copy(header http.Header, srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	reader := bufio.NewReaderSize(src, charsetPrescanSize)
+	peek, _ := reader.Peek(charsetPrescanSize)
+	_, name, certain := charset.DetermineEncoding(peek, header.Get("Content-Type"))
+	if !certain {
+		if prescan := prescanCharset(peek); prescan != "" {
+			name = prescan
+		}
+	}
+	if name == "utf-8" {
+		return nil
+	}
+	utfReader, err := charset.NewReaderLabel(name, reader)
+	if err != nil {
+		return err
+	}
+	return writeFromReader(utfReader, dstPath)
+}
+
 func writeHttpHeader(header http.Header, path string) error {
 	fd, err := os.Create(path)
 	if err != nil {
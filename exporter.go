@@ -0,0 +1,473 @@
+package spider
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ExportRotateRecordsDefault = 10000
+	ExportRotateSizeDefault    = 64 << 20
+)
+
+//导出Result、Item或其他结构体和map, 并发安全
+type Exporter interface {
+	Export(record interface{}) error
+	Close() error
+}
+
+type OptionExporter func(*FileExporter)
+
+//每个文件最多的记录数, 0表示不限制
+func OptionExporterRotateRecords(records int) OptionExporter {
+	return func(fe *FileExporter) {
+		fe.rotateRecords = records
+	}
+}
+
+//每个文件最大的字节数, 0表示不限制
+func OptionExporterRotateSize(size int64) OptionExporter {
+	return func(fe *FileExporter) {
+		fe.rotateSize = size
+	}
+}
+
+//csv的列和顺序, 不在其中的字段被忽略
+//缺省取第一条记录的字段, 之后的记录出现新字段时Export返回错误
+func OptionExporterFields(fields ...string) OptionExporter {
+	return func(fe *FileExporter) {
+		fe.fields = fields
+	}
+}
+
+type exportFormat interface {
+	ext() string
+	//是否只输出表头中的字段
+	columnar() bool
+	header(w io.Writer, fields []string) error
+	record(w io.Writer, fields []string, record *exportRecord) error
+	footer(w io.Writer) error
+}
+
+//写入dir/prefix-00001.ext, 写入中的文件带.part后缀, 轮转时关闭并去掉后缀
+//崩溃时最多丢失一个.part文件
+type FileExporter struct {
+	dir    string
+	prefix string
+	format exportFormat
+
+	rotateRecords int
+	rotateSize    int64
+	fields        []string
+	inferred      bool //fields取自第一条记录
+
+	index   int
+	file    *os.File
+	writer  *bufio.Writer
+	counter *countWriter
+	records int
+
+	mutex sync.Mutex
+}
+
+func NewJsonLinesExporter(dir, prefix string, options ...OptionExporter) (*FileExporter, error) {
+	return newFileExporter(dir, prefix, &jsonLinesFormat{}, options...)
+}
+
+func NewCsvExporter(dir, prefix string, options ...OptionExporter) (*FileExporter, error) {
+	return newFileExporter(dir, prefix, &csvFormat{}, options...)
+}
+
+func NewXmlExporter(dir, prefix string, options ...OptionExporter) (*FileExporter, error) {
+	return newFileExporter(dir, prefix, &xmlFormat{}, options...)
+}
+
+func newFileExporter(dir, prefix string, format exportFormat, options ...OptionExporter) (*FileExporter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	fe := &FileExporter{
+		dir:           dir,
+		prefix:        prefix,
+		format:        format,
+		rotateRecords: ExportRotateRecordsDefault,
+		rotateSize:    ExportRotateSizeDefault,
+	}
+	for _, option := range options {
+		option(fe)
+	}
+	return fe, nil
+}
+
+func (fe *FileExporter) Export(record interface{}) error {
+	rec, err := newExportRecord(record)
+	if err != nil {
+		return err
+	}
+
+	fe.mutex.Lock()
+	defer fe.mutex.Unlock()
+
+	if fe.fields == nil {
+		fe.fields = rec.names
+		fe.inferred = true
+	}
+	if fe.inferred && fe.format.columnar() {
+		if name := unknownField(fe.fields, rec.names); name != "" {
+			return fmt.Errorf("field %s not in header %v, set columns with OptionExporterFields", name, fe.fields)
+		}
+	}
+	if fe.file == nil {
+		if err = fe.open(); err != nil {
+			return err
+		}
+	}
+	if err = fe.format.record(fe.writer, fe.fields, rec); err != nil {
+		return err
+	}
+	if err = fe.writer.Flush(); err != nil {
+		return err
+	}
+	fe.records++
+
+	if (fe.rotateRecords > 0 && fe.records >= fe.rotateRecords) ||
+		(fe.rotateSize > 0 && fe.counter.n >= fe.rotateSize) {
+		return fe.close()
+	}
+	return nil
+}
+
+func (fe *FileExporter) Close() error {
+	fe.mutex.Lock()
+	defer fe.mutex.Unlock()
+
+	if fe.file == nil {
+		return nil
+	}
+	return fe.close()
+}
+
+//已经完成的文件, 只包括prefix-序号.ext, 不包括同目录下前缀相同的其他文件
+func (fe *FileExporter) Files() ([]string, error) {
+	infos, err := ioutil.ReadDir(fe.dir)
+	if err != nil {
+		return nil, err
+	}
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(fe.prefix) + `-\d{5,}` + regexp.QuoteMeta(fe.format.ext()) + `$`)
+	var files []string
+	for _, info := range infos {
+		if !info.IsDir() && pattern.MatchString(info.Name()) {
+			files = append(files, filepath.Join(fe.dir, info.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (fe *FileExporter) path() string {
+	return filepath.Join(fe.dir, fmt.Sprintf("%s-%05d%s", fe.prefix, fe.index, fe.format.ext()))
+}
+
+func (fe *FileExporter) open() error {
+	//跳过已经存在的文件, 不覆盖之前的导出
+	for {
+		fe.index++
+		if _, err := os.Stat(fe.path()); os.IsNotExist(err) {
+			break
+		}
+	}
+	file, err := os.OpenFile(fe.path()+".part", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fe.file = file
+	fe.counter = &countWriter{writer: file}
+	fe.writer = bufio.NewWriter(fe.counter)
+	fe.records = 0
+	if err = fe.format.header(fe.writer, fe.fields); err != nil {
+		return err
+	}
+	return fe.writer.Flush()
+}
+
+func (fe *FileExporter) close() error {
+	file := fe.file
+	fe.file = nil
+
+	err := fe.format.footer(fe.writer)
+	if err == nil {
+		err = fe.writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if errC := file.Close(); err == nil {
+		err = errC
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(fe.path()+".part", fe.path())
+}
+
+func unknownField(fields, names []string) string {
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+type countWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+//字段名和值, 保持字段顺序
+type exportRecord struct {
+	raw    interface{}
+	names  []string
+	values map[string]interface{}
+}
+
+//结构体按声明顺序取json tag名, 忽略"-"; map按key排序
+func newExportRecord(record interface{}) (*exportRecord, error) {
+	rec := &exportRecord{raw: record, values: map[string]interface{}{}}
+	rv := reflect.ValueOf(record)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, errors.New("nil record")
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported record type %s", rv.Type())
+		}
+		for _, key := range rv.MapKeys() {
+			rec.names = append(rec.names, key.String())
+			rec.values[key.String()] = rv.MapIndex(key).Interface()
+		}
+		sort.Strings(rec.names)
+	case reflect.Struct:
+		tp := rv.Type()
+		for i := 0; i < tp.NumField(); i++ {
+			sf := tp.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(sf.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = sf.Name
+			}
+			rec.names = append(rec.names, name)
+			rec.values[name] = rv.Field(i).Interface()
+		}
+	default:
+		return nil, fmt.Errorf("unsupported record type %s", rv.Type())
+	}
+	return rec, nil
+}
+
+//标量直接格式化, 空指针为空字符串, 其他类型编码为json
+func exportString(value interface{}) string {
+	rv := reflect.ValueOf(value)
+	for rv.IsValid() && rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return ""
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return ""
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(rv.Interface())
+	case reflect.Slice, reflect.Map:
+		if rv.Len() == 0 {
+			return ""
+		}
+	}
+	data, err := json.Marshal(rv.Interface())
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+type jsonLinesFormat struct{}
+
+func (jf *jsonLinesFormat) ext() string {
+	return ".jsonl"
+}
+
+func (jf *jsonLinesFormat) columnar() bool {
+	return false
+}
+
+func (jf *jsonLinesFormat) header(w io.Writer, fields []string) error {
+	return nil
+}
+
+//保留原始的json编码, 包括omitempty
+func (jf *jsonLinesFormat) record(w io.Writer, fields []string, record *exportRecord) error {
+	data, err := json.Marshal(record.raw)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (jf *jsonLinesFormat) footer(w io.Writer) error {
+	return nil
+}
+
+//每个文件都有表头
+type csvFormat struct{}
+
+func (cf *csvFormat) ext() string {
+	return ".csv"
+}
+
+func (cf *csvFormat) columnar() bool {
+	return true
+}
+
+func (cf *csvFormat) header(w io.Writer, fields []string) error {
+	writer := csv.NewWriter(w)
+	writer.Write(fields)
+	writer.Flush()
+	return writer.Error()
+}
+
+func (cf *csvFormat) record(w io.Writer, fields []string, record *exportRecord) error {
+	row := make([]string, len(fields))
+	for i, field := range fields {
+		row[i] = exportString(record.values[field])
+	}
+	writer := csv.NewWriter(w)
+	writer.Write(row)
+	writer.Flush()
+	return writer.Error()
+}
+
+func (cf *csvFormat) footer(w io.Writer) error {
+	return nil
+}
+
+//<records><record><field name="x">v</field></record></records>
+//切片的每个元素是一个<value>
+type xmlFormat struct{}
+
+func (xf *xmlFormat) ext() string {
+	return ".xml"
+}
+
+func (xf *xmlFormat) columnar() bool {
+	return false
+}
+
+func (xf *xmlFormat) header(w io.Writer, fields []string) error {
+	_, err := io.WriteString(w, xml.Header+"<records>\n")
+	return err
+}
+
+func (xf *xmlFormat) record(w io.Writer, fields []string, record *exportRecord) error {
+	var builder strings.Builder
+	builder.WriteString("<record>")
+	for _, name := range record.names {
+		value := reflect.ValueOf(record.values[name])
+		if value.Kind() == reflect.Slice && value.Type().Elem().Kind() != reflect.Uint8 {
+			if value.Len() == 0 {
+				continue
+			}
+			builder.WriteString(`<field name="`)
+			xml.EscapeText(&builder, []byte(name))
+			builder.WriteString(`">`)
+			for i := 0; i < value.Len(); i++ {
+				builder.WriteString("<value>")
+				xml.EscapeText(&builder, []byte(exportString(value.Index(i).Interface())))
+				builder.WriteString("</value>")
+			}
+			builder.WriteString("</field>")
+			continue
+		}
+		text := exportString(record.values[name])
+		if text == "" {
+			continue
+		}
+		builder.WriteString(`<field name="`)
+		xml.EscapeText(&builder, []byte(name))
+		builder.WriteString(`">`)
+		xml.EscapeText(&builder, []byte(text))
+		builder.WriteString("</field>")
+	}
+	builder.WriteString("</record>\n")
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (xf *xmlFormat) footer(w io.Writer) error {
+	_, err := io.WriteString(w, "</records>\n")
+	return err
+}
+
+//把Item导出, 导出失败的Item被丢弃
+type ExportStage struct {
+	exporter Exporter
+}
+
+func NewExportStage(exporter Exporter) *ExportStage {
+	return &ExportStage{exporter: exporter}
+}
+
+func (es *ExportStage) Open() error {
+	return nil
+}
+
+//随pipeline关闭exporter
+func (es *ExportStage) Close() error {
+	return es.exporter.Close()
+}
+
+func (es *ExportStage) Process(url string, item Item) (Item, error) {
+	if err := es.exporter.Export(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
@@ -0,0 +1,149 @@
+package spider
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//go test -v -run=Test_CsvExporter
+func Test_CsvExporter(t *testing.T) {
+	dir := t.TempDir()
+	//同目录下前缀相同的其他文件不属于该exporter
+	for _, name := range []string{"items-old.csv", "items-extra-00001.csv", "items-00001.csv.bak"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	exporter, err := NewCsvExporter(dir, "items", OptionExporterRotateRecords(2), OptionExporterFields("price", "tags", "title"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	records := []Item{
+		{"title": "a", "price": 1.5, "tags": []string{"x", "y"}},
+		{"price": int64(2), "title": "b,\"c\""},
+		{"title": "d", "extra": true},
+	}
+	for _, record := range records {
+		if err = exporter.Export(record); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	//未关闭的文件仍然是.part
+	if _, err = os.Stat(filepath.Join(dir, "items-00002.csv.part")); err != nil {
+		t.Error(err)
+	}
+	if err = exporter.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	files, err := exporter.Files()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var contents []string
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		contents = append(contents, string(data))
+	}
+	expected := []string{
+		"price,tags,title\n1.5,\"[\"\"x\"\",\"\"y\"\"]\",a\n2,,\"b,\"\"c\"\"\"\n",
+		"price,tags,title\n,,d\n",
+	}
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("expected %q, got %q", expected, contents)
+	}
+
+	//表头取自第一条记录时, 之后出现的新字段不能被静默丢弃
+	inferred, err := NewCsvExporter(dir, "inferred")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer inferred.Close()
+	if err = inferred.Export(records[0]); err != nil {
+		t.Error(err)
+	}
+	if err = inferred.Export(records[2]); err == nil || !strings.Contains(err.Error(), "extra") {
+		t.Errorf("unknown field should fail, got %v", err)
+	}
+}
+
+//go test -v -run=Test_ResultExporter
+func Test_ResultExporter(t *testing.T) {
+	dir := t.TempDir()
+	bodyPath := "/tmp/body"
+	result := &Result{StatusCode: 200, Suffix: ".html", BodyPath: &bodyPath, Subs: []string{"http://a/1", "http://a/2"}}
+
+	jsonl, err := NewJsonLinesExporter(dir, "results")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	xmlExporter, err := NewXmlExporter(dir, "results")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, exporter := range []Exporter{jsonl, xmlExporter} {
+		if err = exporter.Export(result); err != nil {
+			t.Error(err)
+			return
+		}
+		if err = exporter.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "results-00001.jsonl"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	decoded := &Result{}
+	if err = json.Unmarshal([]byte(strings.TrimSpace(string(data))), decoded); err != nil ||
+		decoded.StatusCode != 200 || *decoded.BodyPath != bodyPath || len(decoded.Subs) != 2 {
+		t.Errorf("unexpected json lines %s, err: %v", data, err)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, "results-00001.xml"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	doc := struct {
+		Records []struct {
+			Fields []struct {
+				Name   string   `xml:"name,attr"`
+				Text   string   `xml:",chardata"`
+				Values []string `xml:"value"`
+			} `xml:"field"`
+		} `xml:"record"`
+	}{}
+	if err = xml.Unmarshal(data, &doc); err != nil || len(doc.Records) != 1 {
+		t.Errorf("unexpected xml %s, err: %v", data, err)
+		return
+	}
+	fields := map[string]string{}
+	for _, field := range doc.Records[0].Fields {
+		fields[field.Name] = field.Text + strings.Join(field.Values, " ")
+	}
+	if fields["status_code"] != "200" || fields["body_path"] != bodyPath || fields["subs"] != "http://a/1 http://a/2" {
+		t.Errorf("unexpected xml fields %v", fields)
+	}
+}
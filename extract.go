@@ -0,0 +1,503 @@
+package spider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/cihub/seelog"
+	"golang.org/x/net/html"
+)
+
+//字段值的类型, 转换失败的值被丢弃
+const (
+	FieldTypeString = iota
+	FieldTypeInt
+	FieldTypeFloat
+	FieldTypeBool
+	FieldTypeUrl //相对于页面url解析为绝对url
+)
+
+//先用Css或XPath选中节点, 再取Attr或文本, 最后用Regex匹配
+//Css和XPath都为空时取当前节点, 当前节点为文档时Regex作用于整个文本
+type Field struct {
+	Name  string
+	Css   string
+	XPath string
+	Attr  string //为空时取文本
+	Regex string //有分组时取第一个分组
+	Type  int
+	All   bool //取所有值, 值为切片
+}
+
+//Pattern匹配url时生效, 为空时匹配所有页面
+//Scope和ScopeXPath为空时整个文档是一个Item, 否则每个匹配的节点是一个Item
+//xml文档的XPath在xml的节点上求值, 需要分组时使用ScopeXPath, Css和Scope仍然作用于按html解析的节点
+type ExtractRule struct {
+	Pattern    string
+	Scope      string
+	ScopeXPath string
+	Fields     []Field
+}
+
+//字段名到值的映射, 值为string、int64、float64、bool或它们的切片
+type Item map[string]interface{}
+
+type ItemSink interface {
+	Emit(url string, items []Item)
+}
+
+type ItemSinkFunc func(url string, items []Item)
+
+func (fn ItemSinkFunc) Emit(url string, items []Item) {
+	fn(url, items)
+}
+
+type OptionExtractProcesser func(*ExtractProcesser)
+
+func OptionExtractProcesserSink(sink ItemSink) OptionExtractProcesser {
+	return func(ep *ExtractProcesser) {
+		ep.sink = sink
+	}
+}
+
+type extractRule struct {
+	pattern    *regexp.Regexp
+	scope      string
+	scopeXPath string
+	fields     []extractField
+}
+
+//Item对应的节点, 按xml解析的文档没有html节点
+type extractScope struct {
+	node *html.Node
+	nav  xpath.NodeNavigator
+}
+
+type extractField struct {
+	Field
+	regex *regexp.Regexp
+}
+
+//按url匹配ExtractRule抽取Item, 不发现链接, 通常和DomProcesser一起路由
+type ExtractProcesser struct {
+	rules []*extractRule
+	sink  ItemSink
+}
+
+func NewExtractProcesser(rules []*ExtractRule, options ...OptionExtractProcesser) (*ExtractProcesser, error) {
+	ep := &ExtractProcesser{}
+	for _, rule := range rules {
+		compiled, err := compileExtractRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		ep.rules = append(ep.rules, compiled)
+	}
+	for _, option := range options {
+		option(ep)
+	}
+	return ep, nil
+}
+
+func compileExtractRule(rule *ExtractRule) (*extractRule, error) {
+	compiled := &extractRule{scope: rule.Scope, scopeXPath: rule.ScopeXPath}
+	if rule.ScopeXPath != "" {
+		if _, err := xpath.Compile(rule.ScopeXPath); err != nil {
+			return nil, fmt.Errorf("scope: %s", err)
+		}
+	}
+	if rule.Pattern != "" {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled.pattern = re
+	}
+	for _, field := range rule.Fields {
+		if field.Name == "" {
+			return nil, errors.New("extract field without name")
+		}
+		f := extractField{Field: field}
+		if field.XPath != "" {
+			if _, err := xpath.Compile(field.XPath); err != nil {
+				return nil, fmt.Errorf("field %s: %s", field.Name, err)
+			}
+		}
+		if field.Regex != "" {
+			re, err := regexp.Compile(field.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", field.Name, err)
+			}
+			f.regex = re
+		}
+		compiled.fields = append(compiled.fields, f)
+	}
+	return compiled, nil
+}
+
+func (ep *ExtractProcesser) Finish() {
+	return
+}
+
+//不在Spider中使用时Item只能通过sink取得
+func (ep *ExtractProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, error) {
+	_, _, err := ep.ProcessItems(charSet, certain, rsp)
+	return nil, err
+}
+
+func (ep *ExtractProcesser) ProcessItems(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, []Item, error) {
+	defer rsp.Body.Close()
+
+	base := rsp.Request.URL
+	var rules []*extractRule
+	for _, rule := range ep.rules {
+		if rule.pattern == nil || rule.pattern.MatchString(base.String()) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil, nil
+	}
+
+	utfReader, err := utf8Reader(rsp, charSet, certain)
+	if err != nil {
+		seelog.Errorf("ExtractProcesser::ProcessItems | utf8 reader charset: %s, err: %s", charSet, err)
+		return nil, nil, err
+	}
+	body, err := ioutil.ReadAll(utfReader)
+	if err != nil {
+		seelog.Errorf("ExtractProcesser::ProcessItems | read all err: %s", err)
+		return nil, nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		seelog.Errorf("ExtractProcesser::ProcessItems | new document err: %s", err)
+		return nil, nil, err
+	}
+	page := &extractPage{base: base, doc: doc, exprs: xpathExprs{}}
+	for _, rule := range rules {
+		if rule.xpath() {
+			page.root = xpathRoot(rsp, body, doc)
+			break
+		}
+	}
+
+	var items []Item
+	for _, rule := range rules {
+		items = append(items, rule.extract(page)...)
+	}
+	if len(items) > 0 && ep.sink != nil {
+		ep.sink.Emit(base.String(), items)
+	}
+	return nil, items, nil
+}
+
+//一个页面的抽取状态, root在有XPath的规则时才解析
+type extractPage struct {
+	base  *url.URL
+	doc   *goquery.Document
+	root  xpath.NodeNavigator
+	exprs xpathExprs
+}
+
+func (rule *extractRule) xpath() bool {
+	if rule.scopeXPath != "" {
+		return true
+	}
+	for _, field := range rule.fields {
+		if field.XPath != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *extractRule) extract(page *extractPage) []Item {
+	var scopes []extractScope
+	switch {
+	case rule.scopeXPath != "":
+		expr, err := page.exprs.compile(rule.scopeXPath)
+		if err != nil {
+			return nil
+		}
+		iter, ok := expr.Evaluate(page.root.Copy()).(*xpath.NodeIterator)
+		if !ok {
+			return nil
+		}
+		for iter.MoveNext() {
+			scope := extractScope{nav: iter.Current().Copy()}
+			if nav, ok := scope.nav.(*htmlquery.NodeNavigator); ok {
+				scope.node = nav.Current()
+			}
+			scopes = append(scopes, scope)
+		}
+	case rule.scope != "":
+		for _, node := range page.doc.Find(rule.scope).Nodes {
+			scopes = append(scopes, extractScope{node: node, nav: htmlquery.CreateXPathNavigator(node)})
+		}
+	default:
+		scopes = append(scopes, extractScope{node: page.doc.Nodes[0], nav: page.root})
+	}
+
+	var items []Item
+	for _, scope := range scopes {
+		item := Item{}
+		for _, field := range rule.fields {
+			if value, ok := field.extract(page, scope); ok {
+				item[field.Name] = value
+			}
+		}
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (field *extractField) extract(page *extractPage, scope extractScope) (interface{}, bool) {
+	var raws []string
+	switch {
+	case field.Css != "":
+		if scope.node == nil {
+			break
+		}
+		goquery.NewDocumentFromNode(scope.node).Find(field.Css).Each(func(_ int, s *goquery.Selection) {
+			raws = append(raws, field.value(s.Nodes[0])...)
+		})
+	case field.XPath != "":
+		if expr, err := page.exprs.compile(field.XPath); err == nil && scope.nav != nil {
+			raws = xpathEach(scope.nav, expr, field.navValue)
+		}
+	case scope.node != nil:
+		raws = field.value(scope.node)
+	default:
+		raws = field.navValue(scope.nav)
+	}
+
+	var values []interface{}
+	for _, raw := range raws {
+		if field.regex != nil {
+			match := field.regex.FindStringSubmatch(raw)
+			if match == nil {
+				continue
+			}
+			raw = match[0]
+			if len(match) > 1 {
+				raw = match[1]
+			}
+		}
+		if value, ok := convertFieldValue(page.base, strings.TrimSpace(raw), field.Type); ok {
+			values = append(values, value)
+		}
+		if !field.All && len(values) > 0 {
+			break
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, false
+	}
+	if !field.All {
+		return values[0], true
+	}
+	return fieldSlice(values, field.Type), true
+}
+
+//取属性或文本
+func (field *extractField) value(node *html.Node) []string {
+	if field.Attr == "" {
+		return []string{htmlquery.InnerText(node)}
+	}
+	for _, attr := range node.Attr {
+		if attr.Key == field.Attr {
+			return []string{attr.Val}
+		}
+	}
+	return nil
+}
+
+//XPath选中的节点: 属性节点直接取值, 其他节点取Attr或文本
+func (field *extractField) navValue(nav xpath.NodeNavigator) []string {
+	if field.Attr == "" || nav.NodeType() == xpath.AttributeNode {
+		return []string{nav.Value()}
+	}
+	if value, ok := xpathAttr(nav, field.Attr); ok {
+		return []string{value}
+	}
+	return nil
+}
+
+func convertFieldValue(base *url.URL, raw string, tp int) (interface{}, bool) {
+	switch tp {
+	case FieldTypeInt:
+		v, err := strconv.ParseInt(strings.ReplaceAll(raw, ",", ""), 10, 64)
+		return v, err == nil
+	case FieldTypeFloat:
+		v, err := strconv.ParseFloat(strings.ReplaceAll(raw, ",", ""), 64)
+		return v, err == nil
+	case FieldTypeBool:
+		v, err := strconv.ParseBool(raw)
+		return v, err == nil
+	case FieldTypeUrl:
+		if raw == "" {
+			return nil, false
+		}
+		u, err := base.Parse(raw)
+		if err != nil {
+			return nil, false
+		}
+		return u.String(), true
+	}
+	return raw, raw != ""
+}
+
+func fieldSlice(values []interface{}, tp int) interface{} {
+	switch tp {
+	case FieldTypeInt:
+		s := make([]int64, len(values))
+		for i, v := range values {
+			s[i] = v.(int64)
+		}
+		return s
+	case FieldTypeFloat:
+		s := make([]float64, len(values))
+		for i, v := range values {
+			s[i] = v.(float64)
+		}
+		return s
+	case FieldTypeBool:
+		s := make([]bool, len(values))
+		for i, v := range values {
+			s[i] = v.(bool)
+		}
+		return s
+	}
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = v.(string)
+	}
+	return s
+}
+
+//从结构体的tag生成Field: item(字段名, 缺省为结构体字段名), css, xpath, attr, regex
+//字段类型决定Field.Type, 切片对应Field.All, 没有css和xpath的结构体字段被忽略
+//
+//	type Article struct {
+//		Title string   `css:"h1"`
+//		Tags  []string `item:"tags" css:".tag"`
+//		Link  string   `xpath:"//link[@rel='canonical']/@href"`
+//	}
+func FieldsOf(v interface{}) []Field {
+	tp := reflect.TypeOf(v)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	if tp.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []Field
+	for i := 0; i < tp.NumField(); i++ {
+		sf := tp.Field(i)
+		css, xp := sf.Tag.Get("css"), sf.Tag.Get("xpath")
+		if sf.PkgPath != "" || (css == "" && xp == "") {
+			continue
+		}
+		field := Field{
+			Name:  sf.Tag.Get("item"),
+			Css:   css,
+			XPath: xp,
+			Attr:  sf.Tag.Get("attr"),
+			Regex: sf.Tag.Get("regex"),
+		}
+		if field.Name == "" {
+			field.Name = sf.Name
+		}
+		kind := sf.Type.Kind()
+		if kind == reflect.Slice {
+			field.All = true
+			kind = sf.Type.Elem().Kind()
+		}
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			field.Type = FieldTypeInt
+		case reflect.Float32, reflect.Float64:
+			field.Type = FieldTypeFloat
+		case reflect.Bool:
+			field.Type = FieldTypeBool
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+//按FieldsOf的规则把Item填入结构体指针
+func (item Item) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("decode target must be a pointer to struct")
+	}
+	rv = rv.Elem()
+	tp := rv.Type()
+	for i := 0; i < tp.NumField(); i++ {
+		sf := tp.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := sf.Tag.Get("item")
+		if name == "" {
+			name = sf.Name
+		}
+		value, ok := item[name]
+		if !ok {
+			continue
+		}
+		if err := decodeItemValue(rv.Field(i), reflect.ValueOf(value)); err != nil {
+			return fmt.Errorf("field %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func decodeItemValue(dst, src reflect.Value) error {
+	if dst.Kind() == reflect.Slice && src.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(dst.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err := decodeItemValue(slice.Index(i), src.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if src.Kind() == reflect.Int64 {
+			dst.SetInt(src.Int())
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if src.Kind() == reflect.Int64 && src.Int() >= 0 {
+			dst.SetUint(uint64(src.Int()))
+			return nil
+		}
+	default:
+		if src.Type().ConvertibleTo(dst.Type()) {
+			dst.Set(src.Convert(dst.Type()))
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot decode %s into %s", src.Type(), dst.Type())
+}
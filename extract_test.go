@@ -0,0 +1,70 @@
+package spider
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testArticle struct {
+	Title string   `css:"h1"`
+	Price float64  `item:"price" css:".price" regex:"([0-9.,]+)"`
+	Tags  []string `item:"tags" xpath:"//ul[@class='tags']/li"`
+	Link  string   `xpath:"//link[@rel='canonical']/@href"`
+	Count int      `xpath:"count(//ul[@class='tags']/li)"`
+}
+
+//go test -v -run=Test_ExtractProcesser
+func Test_ExtractProcesser(t *testing.T) {
+	page := `<html><head><link rel="canonical" href="http://example.com/a/1"></head><body>
+<h1> Hello </h1><span class="price">$1,024.50</span>
+<ul class="tags"><li>go</li><li>spider</li></ul>
+<div class="c"><a href="/u/1">alice</a><p>first</p></div>
+<div class="c"><a href="/u/2">bob</a><p>second</p></div>
+</body></html>`
+
+	var got map[string][]Item
+	sink := ItemSinkFunc(func(url string, items []Item) {
+		got = map[string][]Item{url: items}
+	})
+	ep, err := NewExtractProcesser([]*ExtractRule{
+		{Pattern: `/a/\d+$`, Fields: FieldsOf(testArticle{})},
+		{Pattern: `/a/\d+$`, Scope: "div.c", Fields: []Field{
+			{Name: "user", Css: "a", Attr: "href", Type: FieldTypeUrl},
+			{Name: "text", XPath: "./p"},
+		}},
+		{Pattern: `/b/`, Fields: []Field{{Name: "title", Css: "h1"}}},
+	}, OptionExtractProcesserSink(sink))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rsp := newTestResponse(t, "http://example.com/a/1", "text/html", []byte(page))
+	if _, err = ep.Process("utf-8", true, rsp); err != nil {
+		t.Error(err)
+		return
+	}
+	items := got["http://example.com/a/1"]
+	if len(items) != 3 {
+		t.Errorf("expected 3 items, got %v", items)
+		return
+	}
+
+	var article testArticle
+	if err = items[0].Decode(&article); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := testArticle{Title: "Hello", Price: 1024.5, Tags: []string{"go", "spider"}, Link: "http://example.com/a/1", Count: 2}
+	if !reflect.DeepEqual(article, expected) {
+		t.Errorf("expected %+v, got %+v", expected, article)
+	}
+	comments := []Item{{"user": "http://example.com/u/1", "text": "first"}, {"user": "http://example.com/u/2", "text": "second"}}
+	if !reflect.DeepEqual(items[1:], comments) {
+		t.Errorf("expected %v, got %v", comments, items[1:])
+	}
+
+	if _, err = NewExtractProcesser([]*ExtractRule{{Fields: []Field{{Name: "x", XPath: "//["}}}}); err == nil {
+		t.Error("expected invalid xpath error")
+	}
+}
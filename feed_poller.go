@@ -0,0 +1,174 @@
+package spider
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+)
+
+const (
+	FeedPollIntervalDefault = 10 * time.Minute
+)
+
+type OptionFeedPoller func(*FeedPoller)
+
+func OptionFeedPollerInterval(interval time.Duration) OptionFeedPoller {
+	return func(fp *FeedPoller) {
+		if interval > 0 {
+			fp.interval = interval
+		}
+	}
+}
+
+func OptionFeedPollerClient(client *http.Client) OptionFeedPoller {
+	return func(fp *FeedPoller) {
+		fp.client = client
+	}
+}
+
+type feedState struct {
+	etag         string
+	lastModified string
+	seen         map[string]bool
+}
+
+//定时拉取注册的feed, 只把新条目推给spider
+//spider需要设置OptionSpiderKeepalive, 否则队列清空后Run就会退出
+type FeedPoller struct {
+	spider   *Spider
+	client   *http.Client
+	interval time.Duration
+
+	feeds map[string]*feedState
+	mutex sync.Mutex
+}
+
+func NewFeedPoller(spider *Spider, options ...OptionFeedPoller) *FeedPoller {
+	fp := &FeedPoller{
+		spider:   spider,
+		interval: FeedPollIntervalDefault,
+		feeds:    make(map[string]*feedState),
+	}
+	for _, option := range options {
+		option(fp)
+	}
+	if fp.client == nil {
+		fp.client = &http.Client{Timeout: spider.timeout}
+	}
+	return fp
+}
+
+func (fp *FeedPoller) Register(feedUrl string) *FeedPoller {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	if _, ok := fp.feeds[feedUrl]; !ok {
+		fp.feeds[feedUrl] = &feedState{seen: make(map[string]bool)}
+	}
+	return fp
+}
+
+//立即拉取一次, 之后每个interval拉取一次, 直到ctx结束
+func (fp *FeedPoller) Run(ctx context.Context) {
+	ticker := time.NewTicker(fp.interval)
+	defer ticker.Stop()
+
+	for {
+		fp.Poll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//拉取所有feed一次, 返回推给spider的新条目数
+func (fp *FeedPoller) Poll() int {
+	fp.mutex.Lock()
+	feedUrls := make([]string, 0, len(fp.feeds))
+	for feedUrl := range fp.feeds {
+		feedUrls = append(feedUrls, feedUrl)
+	}
+	fp.mutex.Unlock()
+
+	count := 0
+	for _, feedUrl := range feedUrls {
+		reqs, err := fp.poll(feedUrl)
+		if err != nil {
+			seelog.Errorf("FeedPoller::Poll | poll %s err: %s", feedUrl, err)
+			continue
+		}
+		for _, req := range reqs {
+			fp.spider.AddCrawlRequest(req)
+		}
+		count += len(reqs)
+	}
+	return count
+}
+
+func (fp *FeedPoller) poll(feedUrl string) ([]*CrawlRequest, error) {
+	fp.mutex.Lock()
+	state := fp.feeds[feedUrl]
+	etag, lastModified := state.etag, state.lastModified
+	fp.mutex.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, feedUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range fp.spider.defaultHeader {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	//条件请求, 未变化时服务端返回304
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	rsp, err := fp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if rsp.StatusCode != http.StatusOK {
+		seelog.Warnf("FeedPoller::poll | %s status code: %d", feedUrl, rsp.StatusCode)
+		return nil, nil
+	}
+
+	items, err := ParseFeed(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	state.etag = rsp.Header.Get("ETag")
+	state.lastModified = rsp.Header.Get("Last-Modified")
+	var fresh []*FeedItem
+	for _, item := range items {
+		key := item.ID
+		if key == "" {
+			key = item.Link
+		}
+		if key == "" || state.seen[key] {
+			continue
+		}
+		state.seen[key] = true
+		fresh = append(fresh, item)
+	}
+	//条目的来源页面是feed本身
+	return feedRequests(NewCrawlRequest(rsp.Request), fresh), nil
+}
@@ -0,0 +1,175 @@
+package spider
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cihub/seelog"
+	"golang.org/x/net/html/charset"
+)
+
+//RSS 2.0, RSS 1.0和Atom中的条目
+type FeedItem struct {
+	ID        string    `json:"id,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Link      string    `json:"link"`
+	Published time.Time `json:"published,omitempty"`
+}
+
+type feedDocument struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"` //RSS 1.0的item在根节点下
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	About   string `xml:"about,attr"`
+	PubDate string `xml:"pubDate"`
+	Date    string `xml:"date"` //dc:date
+}
+
+type atomEntry struct {
+	ID        string `xml:"id"`
+	Title     string `xml:"title"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Links     []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+}
+
+//RSS常见的非标准日期格式
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	time.RFC822Z,
+	time.RFC822,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+}
+
+//编码以<?xml encoding?>为准
+func ParseFeed(reader io.Reader) ([]*FeedItem, error) {
+	decoder := xml.NewDecoder(reader)
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+
+	doc := &feedDocument{}
+	if err := decoder.Decode(doc); err != nil {
+		return nil, err
+	}
+
+	var items []*FeedItem
+	for _, item := range append(doc.Channel.Items, doc.Items...) {
+		link := strings.TrimSpace(item.Link)
+		if link == "" {
+			link = strings.TrimSpace(item.About)
+		}
+		id := strings.TrimSpace(item.GUID)
+		if id == "" {
+			id = link
+		}
+		date := item.PubDate
+		if date == "" {
+			date = item.Date
+		}
+		items = append(items, &FeedItem{
+			ID:        id,
+			Title:     strings.TrimSpace(item.Title),
+			Link:      link,
+			Published: parseFeedTime(date),
+		})
+	}
+	for _, entry := range doc.Entries {
+		var link string
+		for _, l := range entry.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = strings.TrimSpace(l.Href)
+				break
+			}
+		}
+		id := strings.TrimSpace(entry.ID)
+		if id == "" {
+			id = link
+		}
+		date := entry.Published
+		if date == "" {
+			date = entry.Updated
+		}
+		items = append(items, &FeedItem{
+			ID:        id,
+			Title:     strings.TrimSpace(entry.Title),
+			Link:      link,
+			Published: parseFeedTime(date),
+		})
+	}
+	return items, nil
+}
+
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+//条目的发布时间通过CrawlRequest.Meta中的"published"传递
+type FeedProcesser struct{}
+
+func NewFeedProcesser() *FeedProcesser {
+	return &FeedProcesser{}
+}
+
+func (fp *FeedProcesser) Finish() {
+	return
+}
+
+func (fp *FeedProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, error) {
+	defer rsp.Body.Close()
+
+	items, err := ParseFeed(rsp.Body)
+	if err != nil {
+		seelog.Errorf("FeedProcesser::Process | parse feed err: %s", err)
+		return nil, err
+	}
+	return feedRequests(CrawlRequestOf(rsp), items), nil
+}
+
+func feedRequests(parent *CrawlRequest, items []*FeedItem) []*CrawlRequest {
+	var reqs []*CrawlRequest
+	for _, item := range items {
+		if item.Link == "" {
+			continue
+		}
+		//条目通常指向其他域名, 不做同域限制
+		linkU, err := parent.URL.Parse(item.Link)
+		if err != nil {
+			continue
+		}
+		req, err := http.NewRequest(http.MethodGet, linkU.String(), nil)
+		if err != nil {
+			continue
+		}
+		child := parent.Child(req, SourceFeed)
+		if !item.Published.IsZero() {
+			child.Meta["published"] = item.Published
+		}
+		reqs = append(reqs, child)
+	}
+	return reqs
+}
@@ -0,0 +1,193 @@
+package spider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//go test -v -run=Test_FeedProcesser
+func Test_FeedProcesser(t *testing.T) {
+	rss := `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0"><channel><title>news</title>
+<item><title>a</title><link>http://other.com/a.html</link><guid>a-1</guid><pubDate>Mon, 02 Jan 2006 15:04:05 +0800</pubDate></item>
+<item><title>b</title><link>/b.html</link></item>
+</channel></rss>`
+	rsp := newTestResponse(t, "http://example.com/rss.xml", "application/rss+xml", []byte(rss))
+	reqs, err := NewFeedProcesser().Process("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var links []string
+	for _, req := range reqs {
+		links = append(links, req.URL.String())
+	}
+	expected := []string{"http://other.com/a.html", "http://example.com/b.html"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %v, got %v", expected, links)
+	}
+	published, ok := reqs[0].Meta["published"].(time.Time)
+	if !ok || published.Unix() != 1136185445 {
+		t.Errorf("unexpected published: %v", published)
+	}
+	if _, ok := reqs[1].Meta["published"]; ok {
+		t.Errorf("unexpected published on item without pubDate")
+	}
+
+	atom := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<entry><id>urn:1</id><title>c</title><link rel="edit" href="/edit/c"/><link href="http://example.com/c.html"/><updated>2006-01-02T15:04:05Z</updated></entry>
+</feed>`
+	items, err := ParseFeed(newTestResponse(t, "http://example.com/atom", "application/atom+xml", []byte(atom)).Body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(items) != 1 || items[0].ID != "urn:1" || items[0].Link != "http://example.com/c.html" ||
+		!items[0].Published.Equal(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected atom items: %+v", items)
+	}
+}
+
+//go test -v -run=Test_FeedPoller
+func Test_FeedPoller(t *testing.T) {
+	var notModified int32
+	items := `<item><link>/1.html</link></item>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"%d"`, len(items))
+		if r.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<rss><channel>` + items + `</channel></rss>`))
+	}))
+	defer server.Close()
+
+	spider := NewSpider()
+	poller := NewFeedPoller(spider).Register(server.URL + "/rss")
+	if count := poller.Poll(); count != 1 {
+		t.Errorf("expected 1 new item, got %d", count)
+	}
+	if count := poller.Poll(); count != 0 || atomic.LoadInt32(&notModified) != 1 {
+		t.Errorf("expected not modified, got %d new items", count)
+	}
+
+	items += `<item><link>/2.html</link></item>`
+	if count := poller.Poll(); count != 1 {
+		t.Errorf("expected 1 new item, got %d", count)
+	}
+	if rest := spider.scheduler.Rest(); rest != 2 {
+		t.Errorf("expected 2 requests scheduled, got %d", rest)
+	}
+}
+
+//go test -v -run=Test_FeedPollerSpider
+func Test_FeedPollerSpider(t *testing.T) {
+	lastModified := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+	rssItems := `<item><link>/1.html</link></item>`
+	mutex := sync.Mutex{}
+	var rssNotModified, atomNotModified int32
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.URL.Path {
+		case "/rss":
+			etag := fmt.Sprintf(`"%d"`, len(rssItems))
+			if r.Header.Get("If-None-Match") == etag {
+				atomic.AddInt32(&rssNotModified, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprint(w, `<rss><channel>`+rssItems+`</channel></rss>`)
+		case "/atom":
+			if r.Header.Get("If-Modified-Since") == lastModified {
+				atomic.AddInt32(&atomNotModified, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Last-Modified", lastModified)
+			w.Header().Set("Content-Type", "application/atom+xml")
+			fmt.Fprint(w, `<feed xmlns="http://www.w3.org/2005/Atom"><entry><id>urn:a</id><link href="/a.html"/></entry></feed>`)
+		default:
+			hits[r.URL.Path]++
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body>item</body></html>`)
+		}
+	}))
+	defer server.Close()
+
+	//默认不处理feed, 条目链接不会被跟进
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/rss", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	result := NewSpider(OptionSpiderSleep(SleepTypeNode, 0, 1)).AddRequest(request).Run().Result()
+	if len(result) != 1 {
+		t.Errorf("feed items should not be followed without a feed processer: %v", result)
+	}
+
+	spider := NewSpider(OptionSpiderSleep(SleepTypeNode, 0, 1), OptionSpiderKeepalive(true))
+	poller := NewFeedPoller(spider, OptionFeedPollerInterval(10*time.Millisecond)).
+		Register(server.URL + "/rss").
+		Register(server.URL + "/atom")
+	done := make(chan struct{})
+	go func() {
+		spider.Run()
+		close(done)
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go poller.Run(ctx)
+
+	waitFor := func(cond func() bool) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			mutex.Lock()
+			ok := cond()
+			mutex.Unlock()
+			if ok {
+				return true
+			}
+		}
+		return false
+	}
+	if !waitFor(func() bool {
+		return hits["/1.html"] == 1 && hits["/a.html"] == 1 &&
+			atomic.LoadInt32(&rssNotModified) > 0 && atomic.LoadInt32(&atomNotModified) > 0
+	}) {
+		t.Errorf("items should be crawled once and feeds revalidated with 304: %v", hits)
+	}
+
+	mutex.Lock()
+	rssItems += `<item><link>/2.html</link></item>`
+	mutex.Unlock()
+	if !waitFor(func() bool { return hits["/2.html"] == 1 }) {
+		t.Errorf("new item should be crawled by the running spider: %v", hits)
+	}
+
+	cancel()
+	spider.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("keepalive spider should exit after Stop")
+	}
+	mutex.Lock()
+	if hits["/1.html"] != 1 || hits["/a.html"] != 1 {
+		t.Errorf("unchanged items should not be crawled again: %v", hits)
+	}
+	mutex.Unlock()
+}
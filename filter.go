@@ -4,7 +4,10 @@ package spider
 //默认Content-Type: application/octet-stream不会被缓存
 type Filter interface {
 	SuffixAllow(suffix string) bool
-	SizeAllow(size int64) bool
+	//size为-1表示长度未知, 由SizeLimit在读取时限制
+	SizeAllow(suffix string, size int64) bool
+	//返回该类型允许读取的最大字节数, 负数表示不限制
+	SizeLimit(suffix string) int64
 	HttpsAllow() bool
 }
 
@@ -17,6 +20,7 @@ func OptionFilterSuffixs(suffixs []string) OptionFilter {
 	}
 }
 
+//0或未设置表示不限制
 func OptionFilterSize(size int64) OptionFilter {
 	return func(lf *LimitFilter) error {
 		lf.limitedSize = size
@@ -24,14 +28,23 @@ func OptionFilterSize(size int64) OptionFilter {
 	}
 }
 
+//单独限制某类型的大小, 优先于OptionFilterSize
+func OptionFilterSuffixSize(suffix string, size int64) OptionFilter {
+	return func(lf *LimitFilter) error {
+		lf.limitedSuffixSizes[suffix] = size
+		return nil
+	}
+}
+
 type LimitFilter struct {
-	limitedSuffixs []string
-	limitedSize    int64
+	limitedSuffixs     []string
+	limitedSize        int64
+	limitedSuffixSizes map[string]int64
 }
 
 func NewLimitFilter(options ...OptionFilter) (*LimitFilter, error) {
 	var err error
-	lm := &LimitFilter{}
+	lm := &LimitFilter{limitedSuffixSizes: make(map[string]int64)}
 	for _, option := range options {
 		if err = option(lm); err != nil {
 			return nil, err
@@ -57,9 +70,21 @@ func (lm *LimitFilter) SuffixAllow(suffix string) bool {
 	return false
 }
 
-func (lm *LimitFilter) SizeAllow(size int64) bool {
-	if size <= lm.limitedSize {
+func (lm *LimitFilter) SizeAllow(suffix string, size int64) bool {
+	limit := lm.SizeLimit(suffix)
+	if size < 0 || limit < 0 || size <= limit {
 		return true
 	}
 	return false
 }
+
+func (lm *LimitFilter) SizeLimit(suffix string) int64 {
+	size, ok := lm.limitedSuffixSizes[suffix]
+	if !ok {
+		size = lm.limitedSize
+	}
+	if size == 0 {
+		return -1
+	}
+	return size
+}
@@ -0,0 +1,293 @@
+package spider
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	FormEnctypeUrlencoded = "application/x-www-form-urlencoded"
+	FormEnctypeMultipart  = "multipart/form-data"
+)
+
+//表单中的控件, select和radio的可选值在Options中
+type FormField struct {
+	Name     string
+	Type     string   //input的type, 以及select-one、select-multiple、textarea
+	Values   []string //缺省提交的值, 未选中的checkbox和radio为空
+	Options  []string
+	Disabled bool
+}
+
+type Form struct {
+	Action  string //已解析为绝对url
+	Method  string
+	Enctype string
+	Fields  []FormField
+}
+
+//返回要提交的值, 每组值生成一个请求, 返回nil表示不提交该表单
+type FormValueProvider interface {
+	Values(page *url.URL, form *Form) []url.Values
+}
+
+type FormValueProviderFunc func(page *url.URL, form *Form) []url.Values
+
+func (fn FormValueProviderFunc) Values(page *url.URL, form *Form) []url.Values {
+	return fn(page, form)
+}
+
+//https://html.spec.whatwg.org/multipage/form-control-infrastructure.html#constructing-the-form-data-set
+//checkbox和radio只取选中的, 按钮只取第一个有名字的submit, 文件控件为空文件名
+func (form *Form) Defaults() url.Values {
+	values := url.Values{}
+	submitted := false
+	for _, field := range form.Fields {
+		if field.Name == "" || field.Disabled {
+			continue
+		}
+		switch field.Type {
+		case "submit":
+			if submitted {
+				continue
+			}
+			submitted = true
+		case "button", "reset", "image":
+			continue
+		}
+		for _, value := range field.Values {
+			values.Add(field.Name, value)
+		}
+	}
+	return values
+}
+
+//GET替换action中的query, POST按enctype编码body
+//值按控件在文档中的顺序提交, 有些服务端依赖这个顺序
+func (form *Form) Request(values url.Values) (*http.Request, error) {
+	ordered := form.ordered(values)
+	if form.Method != http.MethodPost {
+		actionU, err := url.Parse(form.Action)
+		if err != nil {
+			return nil, err
+		}
+		actionU.RawQuery = encodeFormValues(ordered)
+		actionU.Fragment = ""
+		return http.NewRequest(http.MethodGet, actionU.String(), nil)
+	}
+
+	if form.Enctype == FormEnctypeMultipart {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for _, fv := range ordered {
+			//文件控件写为文件名为值的空文件
+			if fv.file {
+				if _, err := writer.CreateFormFile(fv.name, fv.value); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := writer.WriteField(fv.name, fv.value); err != nil {
+				return nil, err
+			}
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodPost, form.Action, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req, nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, form.Action, strings.NewReader(encodeFormValues(ordered)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", FormEnctypeUrlencoded)
+	return req, nil
+}
+
+type formValue struct {
+	name  string
+	value string
+	file  bool
+}
+
+//同名的值在第一个同名控件处连续出现, provider额外添加的值按名字排序放在最后
+func (form *Form) ordered(values url.Values) []formValue {
+	var ordered []formValue
+	written := map[string]bool{}
+	add := func(name string, file bool) {
+		if written[name] {
+			return
+		}
+		written[name] = true
+		for _, value := range values[name] {
+			ordered = append(ordered, formValue{name: name, value: value, file: file})
+		}
+	}
+	for _, field := range form.Fields {
+		add(field.Name, field.Type == "file")
+	}
+	var extra []string
+	for name := range values {
+		if !written[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		add(name, false)
+	}
+	return ordered
+}
+
+//和url.Values.Encode相同的转义, 但不按名字排序
+func encodeFormValues(ordered []formValue) string {
+	var buffer strings.Builder
+	for i, fv := range ordered {
+		if i > 0 {
+			buffer.WriteByte('&')
+		}
+		buffer.WriteString(url.QueryEscape(fv.name))
+		buffer.WriteByte('=')
+		buffer.WriteString(url.QueryEscape(fv.value))
+	}
+	return buffer.String()
+}
+
+//action为空时提交到当前页面, 跨域的表单被忽略
+func ParseForms(base string, doc *goquery.Document) []*Form {
+	var forms []*Form
+	doc.Find("form").Each(func(i int, s *goquery.Selection) {
+		action := strings.TrimSpace(s.AttrOr("action", ""))
+		if action == "" {
+			action = base
+		}
+		action = mergeUrl(base, action)
+		if action == "" {
+			return
+		}
+
+		form := &Form{
+			Action:  action,
+			Method:  http.MethodGet,
+			Enctype: FormEnctypeUrlencoded,
+		}
+		if strings.EqualFold(strings.TrimSpace(s.AttrOr("method", "")), "post") {
+			form.Method = http.MethodPost
+		}
+		if strings.EqualFold(strings.TrimSpace(s.AttrOr("enctype", "")), FormEnctypeMultipart) {
+			form.Enctype = FormEnctypeMultipart
+		}
+
+		radios := map[string]int{}
+		s.Find("input, select, textarea, button").Each(func(i int, control *goquery.Selection) {
+			_, disabled := control.Attr("disabled")
+			field := FormField{
+				Name:     control.AttrOr("name", ""),
+				Disabled: disabled || control.ParentsFiltered("fieldset[disabled]").Length() > 0,
+			}
+			switch goquery.NodeName(control) {
+			case "input":
+				field.Type = strings.ToLower(strings.TrimSpace(control.AttrOr("type", "text")))
+				value := control.AttrOr("value", "")
+				switch field.Type {
+				case "checkbox", "radio":
+					if value == "" {
+						value = "on"
+					}
+					_, checked := control.Attr("checked")
+					if field.Type == "radio" {
+						//同名的radio合并为一个字段
+						if index, ok := radios[field.Name]; ok && field.Name != "" {
+							form.Fields[index].Options = append(form.Fields[index].Options, value)
+							if checked {
+								form.Fields[index].Values = []string{value}
+							}
+							return
+						}
+						field.Options = []string{value}
+						radios[field.Name] = len(form.Fields)
+					}
+					if checked {
+						field.Values = []string{value}
+					}
+				case "file":
+					//没有选择文件时提交空文件名
+					field.Values = []string{""}
+				default:
+					field.Values = []string{value}
+				}
+			case "button":
+				field.Type = strings.ToLower(strings.TrimSpace(control.AttrOr("type", "submit")))
+				field.Values = []string{control.AttrOr("value", "")}
+			case "textarea":
+				field.Type = "textarea"
+				field.Values = []string{control.Text()}
+			case "select":
+				field.Type = "select-one"
+				_, multiple := control.Attr("multiple")
+				if multiple {
+					field.Type = "select-multiple"
+				}
+				var selected []string
+				control.Find("option").Each(func(i int, option *goquery.Selection) {
+					value, ok := option.Attr("value")
+					if !ok {
+						value = strings.TrimSpace(option.Text())
+					}
+					if _, disabled := option.Attr("disabled"); !disabled {
+						field.Options = append(field.Options, value)
+					}
+					if _, ok := option.Attr("selected"); ok {
+						selected = append(selected, value)
+					}
+				})
+				switch {
+				case multiple:
+					field.Values = selected
+				case len(selected) > 0:
+					field.Values = selected[len(selected)-1:]
+				case len(field.Options) > 0:
+					field.Values = field.Options[:1]
+				}
+			}
+			form.Fields = append(form.Fields, field)
+		})
+		forms = append(forms, form)
+	})
+	return forms
+}
+
+//没有provider时只提交缺省值的GET表单
+func formRequests(page *url.URL, forms []*Form, provider FormValueProvider) []*http.Request {
+	var reqs []*http.Request
+	for _, form := range forms {
+		var valuesList []url.Values
+		switch {
+		case provider != nil:
+			valuesList = provider.Values(page, form)
+		case form.Method == http.MethodGet:
+			valuesList = []url.Values{form.Defaults()}
+		}
+		for _, values := range valuesList {
+			req, err := form.Request(values)
+			if err != nil {
+				continue
+			}
+			setReferer(req, page.String())
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
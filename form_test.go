@@ -0,0 +1,105 @@
+package spider
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//go test -v -run=Test_ParseForms
+func Test_ParseForms(t *testing.T) {
+	page := `<html><body>
+<form action="/search?old=1#top">
+	<input name="q" value="spider">
+	<input type="hidden" name="lang" value="zh">
+	<input type="checkbox" name="exact">
+	<input type="checkbox" name="safe" value="1" checked>
+	<input type="radio" name="sort" value="date">
+	<input type="radio" name="sort" value="score" checked>
+	<select name="year"><option>2023</option><option value="2024" selected>this year</option></select>
+	<select name="tag" multiple><option selected>a</option><option>b</option><option selected>c</option></select>
+	<textarea name="note">hi</textarea>
+	<input name="off" value="x" disabled>
+	<fieldset disabled><input name="off2" value="y"></fieldset>
+	<input type="file" name="upload">
+	<input type="submit" name="go" value="Search"><button name="other">Other</button>
+</form>
+<form method="post" action="http://other.com/login"><input name="user"></form>
+</body></html>`
+	rsp := newTestResponse(t, "http://example.com/index.html", "text/html", []byte(page))
+	reqs, err := NewDomProcesser(OptionDomProcesserForms(true, nil), OptionDomProcesserRules()).Process("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	//跨域的POST表单被忽略
+	if len(reqs) != 1 || reqs[0].Method != http.MethodGet {
+		t.Errorf("expected 1 GET request, got %v", reqs)
+		return
+	}
+	u := reqs[0].URL
+	expected := url.Values{
+		"q": {"spider"}, "lang": {"zh"}, "safe": {"1"}, "sort": {"score"}, "year": {"2024"},
+		"tag": {"a", "c"}, "note": {"hi"}, "upload": {""}, "go": {"Search"},
+	}
+	if u.Path != "/search" || u.Fragment != "" || !reflect.DeepEqual(u.Query(), expected) {
+		t.Errorf("expected %v, got %s", expected, u)
+	}
+}
+
+//go test -v -run=Test_FormValueProvider
+func Test_FormValueProvider(t *testing.T) {
+	page := `<form method="post" action="/archive"><input name="token" value="t"><input name="page" value="1"></form>
+<form method="post" enctype="multipart/form-data" action="/upload"><input name="title"><input type="file" name="upload"></form>`
+	provider := FormValueProviderFunc(func(page *url.URL, form *Form) []url.Values {
+		if strings.HasSuffix(form.Action, "/upload") {
+			values := form.Defaults()
+			values.Set("title", "report")
+			return []url.Values{values}
+		}
+		var valuesList []url.Values
+		for _, p := range []string{"2", "3"} {
+			values := form.Defaults()
+			values.Set("page", p)
+			values.Set("extra", "x")
+			valuesList = append(valuesList, values)
+		}
+		return valuesList
+	})
+	rsp := newTestResponse(t, "http://example.com/", "text/html", []byte(page))
+	reqs, err := NewDomProcesser(OptionDomProcesserForms(true, provider), OptionDomProcesserRules()).Process("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(reqs) != 3 {
+		t.Errorf("expected 3 requests, got %v", reqs)
+		return
+	}
+	var bodies []string
+	for _, req := range reqs[:2] {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != FormEnctypeUrlencoded {
+			t.Errorf("unexpected request %s %v", req.Method, req.Header)
+		}
+	}
+	//按文档顺序, 额外的值在最后
+	if !reflect.DeepEqual(bodies, []string{"token=t&page=2&extra=x", "token=t&page=3&extra=x"}) {
+		t.Errorf("unexpected bodies %v", bodies)
+	}
+	if requestKey(reqs[0].Request) == requestKey(reqs[1].Request) {
+		t.Errorf("posts with different bodies should not share a key")
+	}
+
+	//mime/multipart会把空文件名的部分解析为普通字段, 直接检查body
+	body, _ := ioutil.ReadAll(reqs[2].Body)
+	title := strings.Index(string(body), `name="title"`)
+	upload := strings.Index(string(body), `name="upload"; filename=""`+"\r\nContent-Type: application/octet-stream")
+	if title < 0 || upload < title {
+		t.Errorf("file inputs should be sent as empty file parts after title: %s", body)
+	}
+}
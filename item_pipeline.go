@@ -0,0 +1,315 @@
+package spider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cihub/seelog"
+)
+
+const (
+	ItemPipelineWorkersDefault = 4
+	ItemPipelineBufferDefault  = 1024
+)
+
+//Stage返回DropError时Item被丢弃, 其他错误也会丢弃Item, 原因记为错误信息
+type DropError struct {
+	Reason string
+}
+
+func (err *DropError) Error() string {
+	return "item dropped: " + err.Reason
+}
+
+func DropItem(reason string) error {
+	return &DropError{Reason: reason}
+}
+
+//Open在Spider.Run开始时调用, Close在所有Processer Finish之后调用
+//Process可以修改并返回Item, 会被多个goroutine并发调用
+type ItemStage interface {
+	Open() error
+	Process(url string, item Item) (Item, error)
+	Close() error
+}
+
+type ItemPipelineStats struct {
+	In      uint64            `json:"in"`
+	Out     uint64            `json:"out"`
+	Dropped map[string]uint64 `json:"dropped,omitempty"` //原因到数量
+}
+
+type OptionItemPipeline func(*ItemPipeline)
+
+func OptionItemPipelineWorkers(workers int) OptionItemPipeline {
+	return func(ip *ItemPipeline) {
+		if workers > 0 {
+			ip.workers = workers
+		}
+	}
+}
+
+func OptionItemPipelineBuffer(buffer int) OptionItemPipeline {
+	return func(ip *ItemPipeline) {
+		if buffer >= 0 {
+			ip.buffer = buffer
+		}
+	}
+}
+
+type pipelineItem struct {
+	url  string
+	item Item
+}
+
+//Item按顺序流过所有Stage, 不同Item之间并发
+type ItemPipeline struct {
+	stages  []ItemStage
+	workers int
+	buffer  int
+
+	items chan *pipelineItem
+	mutex sync.RWMutex //保护items, Push并发发送, Close独占
+	wg    sync.WaitGroup
+
+	stats      ItemPipelineStats
+	statsMutex sync.Mutex
+}
+
+func NewItemPipeline(stages []ItemStage, options ...OptionItemPipeline) *ItemPipeline {
+	ip := &ItemPipeline{
+		stages:  stages,
+		workers: ItemPipelineWorkersDefault,
+		buffer:  ItemPipelineBufferDefault,
+		stats:   ItemPipelineStats{Dropped: make(map[string]uint64)},
+	}
+	for _, option := range options {
+		option(ip)
+	}
+	return ip
+}
+
+//打开失败时已打开的Stage会被关闭
+func (ip *ItemPipeline) Open() error {
+	for i, stage := range ip.stages {
+		if err := stage.Open(); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				ip.stages[j].Close()
+			}
+			return err
+		}
+	}
+
+	items := make(chan *pipelineItem, ip.buffer)
+	for i := 0; i < ip.workers; i++ {
+		ip.wg.Add(1)
+		go func() {
+			defer ip.wg.Done()
+			for pi := range items {
+				ip.process(pi)
+			}
+		}()
+	}
+
+	ip.mutex.Lock()
+	ip.items = items
+	ip.mutex.Unlock()
+	return nil
+}
+
+//Open之前或Close之后Push的Item被丢弃
+//Push的是Item的副本, Stage修改字段不影响调用方持有的Item, 嵌套的map和slice仍然共享
+func (ip *ItemPipeline) Push(url string, items ...Item) {
+	ip.mutex.RLock()
+	defer ip.mutex.RUnlock()
+
+	for _, item := range items {
+		ip.count(func(stats *ItemPipelineStats) {
+			stats.In++
+			if ip.items == nil {
+				stats.Dropped["pipeline closed"]++
+			}
+		})
+		if ip.items != nil {
+			ip.items <- &pipelineItem{url: url, item: item.copy()}
+		}
+	}
+}
+
+func (item Item) copy() Item {
+	if item == nil {
+		return nil
+	}
+	copied := make(Item, len(item))
+	for k, v := range item {
+		copied[k] = v
+	}
+	return copied
+}
+
+//等待已Push的Item处理完, 再逆序关闭Stage
+func (ip *ItemPipeline) Close() error {
+	ip.mutex.Lock()
+	items := ip.items
+	ip.items = nil
+	ip.mutex.Unlock()
+	if items == nil {
+		return nil
+	}
+
+	close(items)
+	ip.wg.Wait()
+
+	var errs []string
+	for i := len(ip.stages) - 1; i >= 0; i-- {
+		if err := ip.stages[i].Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (ip *ItemPipeline) Stats() ItemPipelineStats {
+	ip.statsMutex.Lock()
+	defer ip.statsMutex.Unlock()
+
+	stats := ip.stats
+	stats.Dropped = make(map[string]uint64, len(ip.stats.Dropped))
+	for reason, count := range ip.stats.Dropped {
+		stats.Dropped[reason] = count
+	}
+	return stats
+}
+
+func (ip *ItemPipeline) process(pi *pipelineItem) {
+	item := pi.item
+	for _, stage := range ip.stages {
+		var err error
+		item, err = stage.Process(pi.url, item)
+		if err == nil && item == nil {
+			err = DropItem("nil item")
+		}
+		if err != nil {
+			reason := err.Error()
+			if dropErr, ok := err.(*DropError); ok {
+				reason = dropErr.Reason
+			} else {
+				seelog.Errorf("ItemPipeline::process | url: %s, stage err: %s", pi.url, err)
+			}
+			ip.count(func(stats *ItemPipelineStats) {
+				stats.Dropped[reason]++
+			})
+			return
+		}
+	}
+	ip.count(func(stats *ItemPipelineStats) {
+		stats.Out++
+	})
+}
+
+func (ip *ItemPipeline) count(fn func(*ItemPipelineStats)) {
+	ip.statsMutex.Lock()
+	defer ip.statsMutex.Unlock()
+
+	fn(&ip.stats)
+}
+
+//校验必需字段
+type ValidateStage struct {
+	required []string
+}
+
+func NewValidateStage(required ...string) *ValidateStage {
+	return &ValidateStage{required: required}
+}
+
+func (vs *ValidateStage) Open() error {
+	return nil
+}
+
+func (vs *ValidateStage) Close() error {
+	return nil
+}
+
+func (vs *ValidateStage) Process(url string, item Item) (Item, error) {
+	for _, name := range vs.required {
+		if _, ok := item[name]; !ok {
+			return nil, DropItem("missing field " + name)
+		}
+	}
+	return item, nil
+}
+
+//按字段组合去重, 没有指定字段时使用所有字段
+type DedupStage struct {
+	keys []string
+
+	seen  map[string]bool
+	mutex sync.Mutex
+}
+
+func NewDedupStage(keys ...string) *DedupStage {
+	return &DedupStage{keys: keys}
+}
+
+func (ds *DedupStage) Open() error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	ds.seen = make(map[string]bool)
+	return nil
+}
+
+func (ds *DedupStage) Close() error {
+	return nil
+}
+
+func (ds *DedupStage) Process(url string, item Item) (Item, error) {
+	//fmt打印map时按key排序, 结果稳定
+	var key string
+	if len(ds.keys) == 0 {
+		key = fmt.Sprintf("%#v", map[string]interface{}(item))
+	} else {
+		values := make([]interface{}, len(ds.keys))
+		for i, name := range ds.keys {
+			values[i] = item[name]
+		}
+		key = fmt.Sprintf("%#v", values)
+	}
+
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	if ds.seen[key] {
+		return nil, DropItem("duplicate")
+	}
+	ds.seen[key] = true
+	return item, nil
+}
+
+//把Item交给ItemSink, 用于接入自定义的导出
+type SinkStage struct {
+	sink ItemSink
+}
+
+func NewSinkStage(sink ItemSink) *SinkStage {
+	return &SinkStage{sink: sink}
+}
+
+func (ss *SinkStage) Open() error {
+	return nil
+}
+
+func (ss *SinkStage) Close() error {
+	return nil
+}
+
+func (ss *SinkStage) Process(url string, item Item) (Item, error) {
+	ss.sink.Emit(url, []Item{item})
+	return item, nil
+}
@@ -0,0 +1,152 @@
+package spider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type recordStage struct {
+	opened, closed bool
+	items          []Item
+	mutex          sync.Mutex
+}
+
+func (rs *recordStage) Open() error {
+	rs.opened = true
+	return nil
+}
+
+func (rs *recordStage) Close() error {
+	rs.closed = true
+	return nil
+}
+
+func (rs *recordStage) Process(url string, item Item) (Item, error) {
+	if item["title"] == "broken" {
+		return nil, errors.New("broken item")
+	}
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	rs.items = append(rs.items, item)
+	item["recorded"] = true
+	return item, nil
+}
+
+//go test -v -run=Test_ItemPipeline
+func Test_ItemPipeline(t *testing.T) {
+	record := &recordStage{}
+	pipeline := NewItemPipeline([]ItemStage{NewValidateStage("title"), NewDedupStage("title"), record},
+		OptionItemPipelineWorkers(2))
+	pipeline.Push("http://example.com/", Item{"title": "early"})
+	if err := pipeline.Open(); err != nil {
+		t.Error(err)
+		return
+	}
+	pipeline.Push("http://example.com/",
+		Item{"title": "a", "n": int64(1)},
+		Item{"title": "a", "n": int64(2)},
+		Item{"n": int64(3)},
+		Item{"title": "broken"},
+		Item{"title": "b"})
+	if err := pipeline.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats := pipeline.Stats()
+	expected := ItemPipelineStats{In: 6, Out: 2, Dropped: map[string]uint64{
+		"pipeline closed":     1,
+		"duplicate":           1,
+		"missing field title": 1,
+		"broken item":         1,
+	}}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+	if !record.opened || !record.closed || len(record.items) != 2 {
+		t.Errorf("unexpected stage state: %+v", record)
+	}
+}
+
+type failStage struct {
+	recordStage
+}
+
+func (fs *failStage) Open() error {
+	return errors.New("open failed")
+}
+
+//go test -v -run=Test_ItemPipelineOpen
+func Test_ItemPipelineOpen(t *testing.T) {
+	opened, failed, unopened := &recordStage{}, &failStage{}, &recordStage{}
+	exporter := &countExporter{records: map[string]int{}}
+	spider := NewSpider(
+		OptionSpiderItemPipeline(NewItemPipeline([]ItemStage{opened, failed, unopened})),
+		OptionSpiderResultExporter(exporter),
+	)
+	spider.Run()
+	if !opened.opened || !opened.closed || failed.closed || unopened.opened || unopened.closed {
+		t.Errorf("only stages opened before the failure should be closed: %+v, %+v, %+v", opened, failed, unopened)
+	}
+	if !exporter.closed {
+		t.Errorf("exporter should be closed when the pipeline fails to open")
+	}
+}
+
+//go test -v -run=Test_SpiderItemPipeline
+func Test_SpiderItemPipeline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/a/1">1</a><a href="/a/2">2</a><a href="/a/3">3</a></body></html>`)
+		case "/a/3":
+			fmt.Fprint(w, `<html><body><h1>first</h1></body></html>`)
+		default:
+			fmt.Fprint(w, `<html><body><h1>`+r.URL.Path[1:]+`</h1><h1>first</h1></body></html>`)
+		}
+	}))
+	defer server.Close()
+
+	extract, err := NewExtractProcesser([]*ExtractRule{{Pattern: `/a/`, Scope: "h1", Fields: []Field{{Name: "title"}}}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	record := &recordStage{}
+	spider := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+		OptionSpiderExtractProcesser(extract),
+		OptionSpiderItemPipeline(NewItemPipeline([]ItemStage{NewDedupStage(), record})),
+	)
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	result := spider.AddRequest(request).Run().Result()
+
+	if page := result[server.URL+"/a/1"]; page == nil || len(page.Items) != 2 || len(page.Subs) != 0 {
+		t.Errorf("unexpected result: %+v", page)
+	}
+	for _, page := range result {
+		for _, item := range page.Items {
+			if _, ok := item["recorded"]; ok {
+				t.Errorf("stages should not modify items of result: %+v", item)
+			}
+		}
+	}
+	stats := spider.Stats()
+	if stats.Results != 4 || stats.Items.In != 5 || stats.Items.Out != 3 || stats.Items.Dropped["duplicate"] != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if !record.closed || len(record.items) != 3 {
+		t.Errorf("pipeline should be closed after run with 3 items, got %+v", record)
+	}
+}
@@ -0,0 +1,486 @@
+package spider
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/cihub/seelog"
+)
+
+//从脚本中发现链接, 发现的请求为PriorityLow
+type JsProcesser struct{}
+
+func NewJsProcesser() *JsProcesser {
+	return &JsProcesser{}
+}
+
+func (jp *JsProcesser) Finish() {
+	return
+}
+
+func (jp *JsProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, error) {
+	defer rsp.Body.Close()
+
+	utfReader, err := utf8Reader(rsp, charSet, certain)
+	if err != nil {
+		seelog.Errorf("JsProcesser::Process | utf8 reader charset: %s, err: %s", charSet, err)
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(utfReader)
+	if err != nil {
+		seelog.Errorf("JsProcesser::Process | read all err: %s", err)
+		return nil, err
+	}
+
+	//脚本中的相对路径相对于引用它的页面
+	parent := CrawlRequestOf(rsp)
+	base := rsp.Request.URL.String()
+	if parent.Parent != "" {
+		base = parent.Parent
+	}
+	return scriptRequests(parent, base, mergeUrls(base, ExtractJsLinks(string(data)))), nil
+}
+
+func scriptRequests(parent *CrawlRequest, refer string, links []string) []*CrawlRequest {
+	reqs := parent.Children(referRequests(refer, links), SourceScript)
+	for _, req := range reqs {
+		req.Priority = PriorityLow
+	}
+	return reqs
+}
+
+//明确是url的位置: fetch(x), xhr.open(method, x), location = x, location.href = x,
+//location.assign(x), location.replace(x), window.open(x), {url: x}
+//其他位置的字符串需要看起来像url
+func ExtractJsLinks(src string) []string {
+	tokens := newJsLexer(src).tokens()
+
+	var links []string
+	seen := map[string]bool{}
+	for i, token := range tokens {
+		if token.kind != jsTokenString {
+			continue
+		}
+		link := strings.TrimSpace(token.value)
+		if link == "" || seen[link] {
+			continue
+		}
+		if !jsUrlContext(tokens, i) && !jsUrlLike(link) {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+	}
+	return links
+}
+
+func jsUrlContext(tokens []jsToken, i int) bool {
+	at := func(offset int, kind int, values ...string) bool {
+		j := i + offset
+		if j < 0 || j >= len(tokens) || tokens[j].kind != kind {
+			return false
+		}
+		if len(values) == 0 {
+			return true
+		}
+		for _, value := range values {
+			if tokens[j].value == value {
+				return true
+			}
+		}
+		return false
+	}
+
+	//fetch(x)
+	if at(-1, jsTokenPunct, "(") && at(-2, jsTokenIdent, "fetch") {
+		return true
+	}
+	//xhr.open("GET", x)
+	if at(-1, jsTokenPunct, ",") && at(-2, jsTokenString) && at(-3, jsTokenPunct, "(") &&
+		at(-4, jsTokenIdent, "open") && at(-5, jsTokenPunct, ".") {
+		return true
+	}
+	//location.assign(x), location.replace(x), window.open(x)
+	if at(-1, jsTokenPunct, "(") && at(-3, jsTokenPunct, ".") &&
+		((at(-2, jsTokenIdent, "assign", "replace") && at(-4, jsTokenIdent, "location")) ||
+			(at(-2, jsTokenIdent, "open") && at(-4, jsTokenIdent, "window"))) {
+		return true
+	}
+	//location = x, location.href = x
+	if at(-1, jsTokenPunct, "=") &&
+		(at(-2, jsTokenIdent, "location") ||
+			(at(-2, jsTokenIdent, "href") && at(-3, jsTokenPunct, ".") && at(-4, jsTokenIdent, "location"))) {
+		return true
+	}
+	//{url: x}
+	if at(-1, jsTokenPunct, ":") && (at(-2, jsTokenIdent, "url", "href") || at(-2, jsTokenString, "url", "href")) {
+		return true
+	}
+	return false
+}
+
+//服务端脚本的常见后缀, 其他后缀查MimeRegistry
+var jsServerExts = map[string]bool{
+	".asp":   true,
+	".aspx":  true,
+	".cgi":   true,
+	".do":    true,
+	".jsp":   true,
+	".php":   true,
+	".shtml": true,
+}
+
+func jsUrlLike(s string) bool {
+	if len(s) < 2 || len(s) > 2048 {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(`<>"'{}|\^`+"`", r) {
+			return false
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(s, "http://"), strings.HasPrefix(s, "https://"):
+		return len(s) > len("https://")
+	case strings.HasPrefix(s, "//"):
+		//协议相对url需要有域名
+		host := strings.SplitN(s[2:], "/", 2)[0]
+		return strings.Contains(host, ".")
+	case strings.HasPrefix(s, "/"), strings.HasPrefix(s, "./"), strings.HasPrefix(s, "../"):
+		return !strings.HasPrefix(s, "/*")
+	}
+
+	//text/html这样的相对路径需要有已知后缀
+	if !strings.Contains(s, "/") && !strings.Contains(s, ".") {
+		return false
+	}
+	p := s
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	ext := strings.ToLower(path.Ext(p))
+	if ext == "" {
+		return false
+	}
+	if jsServerExts[ext] {
+		return true
+	}
+	_, ok := DefaultMimeRegistry.MediaType(ext)
+	return ok
+}
+
+const (
+	jsTokenEOF = iota
+	jsTokenIdent
+	jsTokenNumber
+	jsTokenString //字符串以及不含${}的模板字符串
+	jsTokenTemplate
+	jsTokenRegex
+	jsTokenPunct
+)
+
+type jsToken struct {
+	kind  int
+	value string
+}
+
+//ECMAScript词法分析, 只区分发现url需要的token
+//https://tc39.es/ecma262/#sec-ecmascript-language-lexical-grammar
+type jsLexer struct {
+	src string
+	pos int
+
+	last jsToken
+	//{的栈, true表示模板字符串中的${
+	braces []bool
+}
+
+func newJsLexer(src string) *jsLexer {
+	return &jsLexer{src: src}
+}
+
+func (lexer *jsLexer) tokens() []jsToken {
+	var tokens []jsToken
+	for {
+		token := lexer.next()
+		if token.kind == jsTokenEOF {
+			return tokens
+		}
+		tokens = append(tokens, token)
+		lexer.last = token
+	}
+}
+
+func (lexer *jsLexer) next() jsToken {
+	lexer.skip()
+	if lexer.pos >= len(lexer.src) {
+		return jsToken{kind: jsTokenEOF}
+	}
+
+	c := lexer.src[lexer.pos]
+	switch {
+	case c == '"' || c == '\'':
+		return jsToken{kind: jsTokenString, value: lexer.string(c)}
+
+	case c == '`':
+		lexer.pos++
+		return lexer.template()
+
+	case c == '}' && len(lexer.braces) > 0 && lexer.braces[len(lexer.braces)-1]:
+		//${...}结束, 继续模板字符串
+		lexer.braces = lexer.braces[:len(lexer.braces)-1]
+		lexer.pos++
+		lexer.template()
+		return jsToken{kind: jsTokenTemplate}
+
+	case c == '/' && lexer.regexAllowed():
+		return jsToken{kind: jsTokenRegex, value: lexer.regex()}
+
+	case c >= '0' && c <= '9' || (c == '.' && lexer.pos+1 < len(lexer.src) && isDigit(lexer.src[lexer.pos+1])):
+		start := lexer.pos
+		for lexer.pos < len(lexer.src) && (isJsIdentPart(lexer.src[lexer.pos]) || lexer.src[lexer.pos] == '.') {
+			lexer.pos++
+		}
+		return jsToken{kind: jsTokenNumber, value: lexer.src[start:lexer.pos]}
+
+	case isJsIdentStart(c):
+		start := lexer.pos
+		for lexer.pos < len(lexer.src) && isJsIdentPart(lexer.src[lexer.pos]) {
+			lexer.pos++
+		}
+		return jsToken{kind: jsTokenIdent, value: lexer.src[start:lexer.pos]}
+	}
+
+	return jsToken{kind: jsTokenPunct, value: lexer.punct()}
+}
+
+//空白, 换行和注释
+func (lexer *jsLexer) skip() {
+	for lexer.pos < len(lexer.src) {
+		rest := lexer.src[lexer.pos:]
+		switch {
+		case strings.HasPrefix(rest, "//"), strings.HasPrefix(rest, "<!--"):
+			end := strings.IndexAny(rest, "\n\r")
+			if end < 0 {
+				lexer.pos = len(lexer.src)
+				return
+			}
+			lexer.pos += end
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				lexer.pos = len(lexer.src)
+				return
+			}
+			lexer.pos += end + 4
+		default:
+			r, size := utf8.DecodeRuneInString(rest)
+			if !unicode.IsSpace(r) && r != '\uFEFF' {
+				return
+			}
+			lexer.pos += size
+		}
+	}
+}
+
+//上一个token之后能否出现正则
+func (lexer *jsLexer) regexAllowed() bool {
+	switch lexer.last.kind {
+	case jsTokenEOF:
+		return true
+	case jsTokenPunct:
+		return lexer.last.value != ")" && lexer.last.value != "]"
+	case jsTokenIdent:
+		switch lexer.last.value {
+		case "return", "typeof", "instanceof", "in", "of", "new", "delete", "void",
+			"throw", "case", "do", "else", "yield", "await":
+			return true
+		}
+	}
+	return false
+}
+
+func (lexer *jsLexer) string(quote byte) string {
+	var builder strings.Builder
+	lexer.pos++
+	for lexer.pos < len(lexer.src) {
+		c := lexer.src[lexer.pos]
+		switch c {
+		case quote:
+			lexer.pos++
+			return builder.String()
+		case '\n', '\r':
+			//未闭合的字符串
+			return builder.String()
+		case '\\':
+			builder.WriteString(lexer.escape())
+		default:
+			builder.WriteByte(c)
+			lexer.pos++
+		}
+	}
+	return builder.String()
+}
+
+//从`或}之后开始, 到`或${为止
+//不含${}的模板字符串按字符串处理
+func (lexer *jsLexer) template() jsToken {
+	var builder strings.Builder
+	for lexer.pos < len(lexer.src) {
+		c := lexer.src[lexer.pos]
+		switch {
+		case c == '`':
+			lexer.pos++
+			return jsToken{kind: jsTokenString, value: builder.String()}
+		case c == '$' && lexer.pos+1 < len(lexer.src) && lexer.src[lexer.pos+1] == '{':
+			lexer.pos += 2
+			lexer.braces = append(lexer.braces, true)
+			return jsToken{kind: jsTokenTemplate, value: builder.String()}
+		case c == '\\':
+			builder.WriteString(lexer.escape())
+		default:
+			builder.WriteByte(c)
+			lexer.pos++
+		}
+	}
+	return jsToken{kind: jsTokenTemplate, value: builder.String()}
+}
+
+func (lexer *jsLexer) regex() string {
+	start := lexer.pos
+	lexer.pos++
+	inClass := false
+	for lexer.pos < len(lexer.src) {
+		c := lexer.src[lexer.pos]
+		lexer.pos++
+		switch {
+		case c == '\\':
+			lexer.pos++
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '/' && !inClass:
+			for lexer.pos < len(lexer.src) && isJsIdentPart(lexer.src[lexer.pos]) {
+				lexer.pos++
+			}
+			return lexer.src[start:lexer.pos]
+		case c == '\n' || c == '\r':
+			return lexer.src[start:lexer.pos]
+		}
+	}
+	return lexer.src[start:]
+}
+
+//https://tc39.es/ecma262/#sec-punctuators 最长匹配
+var jsPuncts = []string{
+	">>>=", "...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--", "+=", "-=", "*=", "/=",
+	"%=", "&=", "|=", "^=", "<<", ">>", "**",
+}
+
+func (lexer *jsLexer) punct() string {
+	rest := lexer.src[lexer.pos:]
+	for _, punct := range jsPuncts {
+		if strings.HasPrefix(rest, punct) {
+			lexer.pos += len(punct)
+			return punct
+		}
+	}
+	c := lexer.src[lexer.pos]
+	switch c {
+	case '{':
+		lexer.braces = append(lexer.braces, false)
+	case '}':
+		if len(lexer.braces) > 0 {
+			lexer.braces = lexer.braces[:len(lexer.braces)-1]
+		}
+	}
+	_, size := utf8.DecodeRuneInString(rest)
+	lexer.pos += size
+	return rest[:size]
+}
+
+//pos指向反斜杠
+func (lexer *jsLexer) escape() string {
+	lexer.pos++
+	if lexer.pos >= len(lexer.src) {
+		return ""
+	}
+	c := lexer.src[lexer.pos]
+	lexer.pos++
+	switch c {
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case 'r':
+		return "\r"
+	case 'b':
+		return "\b"
+	case 'f':
+		return "\f"
+	case 'v':
+		return "\v"
+	case '0':
+		return "\x00"
+	case '\r':
+		if lexer.pos < len(lexer.src) && lexer.src[lexer.pos] == '\n' {
+			lexer.pos++
+		}
+		return ""
+	case '\n':
+		return ""
+	case 'x':
+		return lexer.hex(2)
+	case 'u':
+		if lexer.pos < len(lexer.src) && lexer.src[lexer.pos] == '{' {
+			end := strings.IndexByte(lexer.src[lexer.pos:], '}')
+			if end < 0 {
+				return ""
+			}
+			code, err := strconv.ParseUint(lexer.src[lexer.pos+1:lexer.pos+end], 16, 32)
+			lexer.pos += end + 1
+			if err != nil || code > utf8.MaxRune {
+				return string(utf8.RuneError)
+			}
+			return string(rune(code))
+		}
+		return lexer.hex(4)
+	}
+	_, size := utf8.DecodeRuneInString(lexer.src[lexer.pos-1:])
+	lexer.pos += size - 1
+	return lexer.src[lexer.pos-size : lexer.pos]
+}
+
+func (lexer *jsLexer) hex(n int) string {
+	if lexer.pos+n > len(lexer.src) {
+		lexer.pos = len(lexer.src)
+		return ""
+	}
+	code, err := strconv.ParseUint(lexer.src[lexer.pos:lexer.pos+n], 16, 32)
+	lexer.pos += n
+	if err != nil {
+		return string(utf8.RuneError)
+	}
+	return string(rune(code))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isJsIdentStart(c byte) bool {
+	return c == '$' || c == '_' || c == '\\' || c >= utf8.RuneSelf ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isJsIdentPart(c byte) bool {
+	return isJsIdentStart(c) || isDigit(c)
+}
@@ -0,0 +1,83 @@
+package spider
+
+import (
+	"reflect"
+	"testing"
+)
+
+//go test -v -run=Test_ExtractJsLinks
+func Test_ExtractJsLinks(t *testing.T) {
+	js := `
+// fetch("/commented.json")
+/* location = "/commented-too" */
+var re = /"not-a-string\/path.html"/g, half = total / 2, other = "/ratio" / 2;
+fetch('/api/items?page=' + page);
+fetch("api/v1/users");
+var xhr = new XMLHttpRequest(); xhr.open("POST", "submit");
+window.location.href = "/next-page";
+location.replace('../back');
+window.open("popup");
+$.ajax({url: "/ajax/endpoint", type: "application/json"});
+var img = "images/logo.png", mime = "text/html", word = "hello", tpl = ` + "`/static/${name}.css`" + `;
+var plain = ` + "`/about.html`" + `;
+var cdn = "//cdn.example.com/lib.js", abs = "https://example.com/x";
+`
+	expected := []string{
+		"/ratio",
+		"/api/items?page=",
+		"api/v1/users",
+		"submit",
+		"/next-page",
+		"../back",
+		"popup",
+		"/ajax/endpoint",
+		"images/logo.png",
+		"/about.html",
+		"//cdn.example.com/lib.js",
+		"https://example.com/x",
+	}
+	if links := ExtractJsLinks(js); !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %v, got %v", expected, links)
+	}
+}
+
+//go test -v -run=Test_JsProcesser
+func Test_JsProcesser(t *testing.T) {
+	rsp := newTestResponse(t, "http://example.com/static/app.js", "text/javascript",
+		[]byte(`fetch("data.json"); load("//cdn.example.com/lib.js")`))
+	page := NewCrawlRequest(newTestResponse(t, "http://example.com/app/index.html", "text/html", nil).Request)
+	script := page.Child(rsp.Request, SourceScript)
+	script.bind()
+	rsp.Request = script.Request
+	reqs, err := NewJsProcesser().Process("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(reqs) != 1 || reqs[0].URL.String() != "http://example.com/app/data.json" || reqs[0].Priority != PriorityLow || reqs[0].Source != SourceScript {
+		t.Errorf("script links should resolve against the page, stay on its host and be low priority: %v", reqs)
+	}
+
+	//内联脚本中其他host的url只记录不跟进
+	rsp = newTestResponse(t, "http://example.com/", "text/html",
+		[]byte(`<script>var cdn = "//cdn.example.com/lib.js"; fetch("/local.json");</script>`))
+	reqs, links, err := NewDomProcesser().ProcessLinks("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(reqs) != 1 || reqs[0].URL.String() != "http://example.com/local.json" {
+		t.Errorf("only same-host script links should be followed: %v", reqs)
+	}
+	if len(links) != 2 || links[0].Target != "http://cdn.example.com/lib.js" || links[0].Followed || links[0].Reason != LinkReasonExternal {
+		t.Errorf("cross-host script link should be recorded as external: %v", links)
+	}
+
+	scheduler := NewSchedulerChan()
+	scheduler.Push(reqs[0])
+	normal := NewCrawlRequest(newTestResponse(t, "http://example.com/normal", "text/html", nil).Request)
+	scheduler.Push(normal)
+	if scheduler.Poll() != normal || scheduler.Poll() != reqs[0] || scheduler.Poll() != nil {
+		t.Errorf("scheduler should poll normal priority first")
+	}
+}
@@ -0,0 +1,376 @@
+package spider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cihub/seelog"
+)
+
+type OptionJsonProcesser func(*JsonProcesser)
+
+//条目url的路径, 比如data.items[*].url
+func OptionJsonProcesserItems(paths ...string) OptionJsonProcesser {
+	return func(jp *JsonProcesser) {
+		for _, path := range paths {
+			jp.items = append(jp.items, compileJsonPath(path))
+		}
+	}
+}
+
+//下一页url的路径, 比如links.next
+func OptionJsonProcesserNext(path string) OptionJsonProcesser {
+	return func(jp *JsonProcesser) {
+		jp.next = compileJsonPath(path)
+	}
+}
+
+//页码翻页, 当前页取自请求中的param, 缺省为1
+//total为总页数的路径, 为空时翻到没有条目为止, 没有设置条目路径时翻到文档中没有非空数组为止
+func OptionJsonProcesserPage(param, total string) OptionJsonProcesser {
+	return func(jp *JsonProcesser) {
+		jp.pageParam = param
+		if total != "" {
+			jp.pageTotal = compileJsonPath(total)
+		}
+	}
+}
+
+//游标翻页, path处的游标放入下一页请求的param中
+func OptionJsonProcesserCursor(path, param string) OptionJsonProcesser {
+	return func(jp *JsonProcesser) {
+		jp.cursor = compileJsonPath(path)
+		jp.cursorParam = param
+	}
+}
+
+//是否使用RFC 8288 Link头中rel="next"的链接, 缺省为true
+func OptionJsonProcesserLinkHeader(enabled bool) OptionJsonProcesser {
+	return func(jp *JsonProcesser) {
+		jp.linkHeader = enabled
+	}
+}
+
+//按路径表达式从JSON接口中取出条目和下一页
+type JsonProcesser struct {
+	items []jsonPath
+
+	next        jsonPath
+	pageParam   string
+	pageTotal   jsonPath
+	cursor      jsonPath
+	cursorParam string
+	linkHeader  bool
+}
+
+func NewJsonProcesser(options ...OptionJsonProcesser) *JsonProcesser {
+	jp := &JsonProcesser{linkHeader: true}
+	for _, option := range options {
+		option(jp)
+	}
+	return jp
+}
+
+func (jp *JsonProcesser) Finish() {
+	return
+}
+
+func (jp *JsonProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, error) {
+	defer rsp.Body.Close()
+
+	//JSON只能是UTF-8, 数字保留原文以便作为页码和游标
+	decoder := json.NewDecoder(rsp.Body)
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		seelog.Errorf("JsonProcesser::Process | decode err: %s", err)
+		return nil, err
+	}
+
+	parent := CrawlRequestOf(rsp)
+	base := rsp.Request.URL
+	var reqs []*CrawlRequest
+	var links []string
+	for _, path := range jp.items {
+		links = append(links, jsonStrings(path.eval(doc))...)
+	}
+	seen := map[string]bool{}
+	for _, link := range links {
+		//条目与页面中的链接一样只跟进同host的url
+		u := mergeUrl(base.String(), link)
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			continue
+		}
+		setReferer(req, base.String())
+		reqs = append(reqs, parent.Child(req, SourceApi))
+	}
+
+	hasItems := len(links) > 0
+	if len(jp.items) == 0 {
+		hasItems = jsonHasItems(doc)
+	}
+	if next := jp.nextPage(doc, rsp, hasItems); next != nil && !seen[next.String()] {
+		req, err := http.NewRequest(http.MethodGet, next.String(), nil)
+		if err == nil {
+			//接口通常依赖Accept和鉴权头, 翻页沿用原请求的头
+			req.Header = rsp.Request.Header.Clone()
+			reqs = append(reqs, parent.Child(req, SourcePagination))
+		}
+	}
+	return reqs, nil
+}
+
+//依次尝试下一页字段、Link头、游标和页码
+func (jp *JsonProcesser) nextPage(doc interface{}, rsp *http.Response, hasItems bool) *url.URL {
+	base := rsp.Request.URL
+
+	if jp.next != nil {
+		if values := jsonStrings(jp.next.eval(doc)); len(values) > 0 {
+			if u, err := base.Parse(values[0]); err == nil && u.String() != base.String() {
+				return u
+			}
+		}
+	}
+
+	if jp.linkHeader {
+		if links := ParseLinkHeader(rsp.Header.Values("Link"))["next"]; len(links) > 0 {
+			if u, err := base.Parse(links[0]); err == nil && u.String() != base.String() {
+				return u
+			}
+		}
+	}
+
+	if jp.cursor != nil && jp.cursorParam != "" {
+		values := jsonStrings(jp.cursor.eval(doc))
+		query := base.Query()
+		//游标没变时停止, 避免死循环
+		if len(values) > 0 && values[0] != query.Get(jp.cursorParam) {
+			query.Set(jp.cursorParam, values[0])
+			u := *base
+			u.RawQuery = query.Encode()
+			return &u
+		}
+	}
+
+	if jp.pageParam != "" {
+		query := base.Query()
+		page := 1
+		if value := query.Get(jp.pageParam); value != "" {
+			var err error
+			if page, err = strconv.Atoi(value); err != nil {
+				return nil
+			}
+		}
+		if jp.pageTotal != nil {
+			values := jsonStrings(jp.pageTotal.eval(doc))
+			if len(values) == 0 {
+				return nil
+			}
+			total, err := strconv.Atoi(values[0])
+			if err != nil || page >= total {
+				return nil
+			}
+		} else if !hasItems {
+			return nil
+		}
+		query.Set(jp.pageParam, strconv.Itoa(page+1))
+		u := *base
+		u.RawQuery = query.Encode()
+		return &u
+	}
+	return nil
+}
+
+//https://www.rfc-editor.org/rfc/rfc8288#section-3
+//返回rel到链接的映射, rel可以有多个值
+func ParseLinkHeader(values []string) map[string][]string {
+	links := map[string][]string{}
+	for _, value := range values {
+		for len(value) > 0 {
+			start := strings.IndexByte(value, '<')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(value[start:], '>')
+			if end < 0 {
+				break
+			}
+			target := value[start+1 : start+end]
+			value = value[start+end+1:]
+
+			//参数到下一个逗号为止, 引号中的逗号不算
+			params := value
+			value = ""
+			inQuote := false
+			for i := 0; i < len(params); i++ {
+				if params[i] == '"' {
+					inQuote = !inQuote
+				}
+				if params[i] == ',' && !inQuote {
+					params, value = params[:i], params[i+1:]
+					break
+				}
+			}
+
+			for _, param := range strings.Split(params, ";") {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(kv[1]), `"`)) {
+					rel = strings.ToLower(rel)
+					links[rel] = append(links[rel], target)
+				}
+			}
+		}
+	}
+	return links
+}
+
+const (
+	jsonPathKey = iota
+	jsonPathIndex
+	jsonPathWildcard
+)
+
+type jsonPathStep struct {
+	kind  int
+	key   string
+	index int
+}
+
+//$.a.b[0].c[*], ['a.b'], 对数组取key时作用于每个元素
+type jsonPath []jsonPathStep
+
+func compileJsonPath(path string) jsonPath {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	steps := jsonPath{}
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				end = len(path) - i
+			}
+			inner := strings.TrimSpace(path[i+1 : i+end])
+			i += end + 1
+			if inner == "*" {
+				steps = append(steps, jsonPathStep{kind: jsonPathWildcard})
+			} else if index, err := strconv.Atoi(inner); err == nil {
+				steps = append(steps, jsonPathStep{kind: jsonPathIndex, index: index})
+			} else {
+				steps = append(steps, jsonPathStep{kind: jsonPathKey, key: strings.Trim(inner, `'"`)})
+			}
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			key := path[i : i+end]
+			i += end
+			if key == "*" {
+				steps = append(steps, jsonPathStep{kind: jsonPathWildcard})
+			} else {
+				steps = append(steps, jsonPathStep{kind: jsonPathKey, key: key})
+			}
+		}
+	}
+	return steps
+}
+
+func (path jsonPath) eval(doc interface{}) []interface{} {
+	values := []interface{}{doc}
+	for _, step := range path {
+		var next []interface{}
+		for _, value := range values {
+			next = append(next, step.eval(value)...)
+		}
+		values = next
+	}
+	return values
+}
+
+func (step jsonPathStep) eval(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		switch step.kind {
+		case jsonPathKey:
+			if child, ok := v[step.key]; ok {
+				return []interface{}{child}
+			}
+		case jsonPathWildcard:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			children := make([]interface{}, 0, len(keys))
+			for _, key := range keys {
+				children = append(children, v[key])
+			}
+			return children
+		}
+	case []interface{}:
+		switch step.kind {
+		case jsonPathIndex:
+			index := step.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index >= 0 && index < len(v) {
+				return []interface{}{v[index]}
+			}
+		case jsonPathWildcard:
+			return v
+		case jsonPathKey:
+			var children []interface{}
+			for _, elem := range v {
+				children = append(children, step.eval(elem)...)
+			}
+			return children
+		}
+	}
+	return nil
+}
+
+//字符串和数字转为字符串, 忽略null、布尔和对象
+//文档中是否有非空数组
+func jsonHasItems(value interface{}) bool {
+	switch v := value.(type) {
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		for _, child := range v {
+			if jsonHasItems(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonStrings(values []interface{}) []string {
+	var ss []string
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				ss = append(ss, v)
+			}
+		case json.Number:
+			ss = append(ss, v.String())
+		}
+	}
+	return ss
+}
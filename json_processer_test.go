@@ -0,0 +1,84 @@
+package spider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func jsonProcess(t *testing.T, jp *JsonProcesser, rawurl, link, body string) []string {
+	rsp := newTestResponse(t, rawurl, "application/json", []byte(body))
+	if link != "" {
+		rsp.Header.Set("Link", link)
+	}
+	reqs, err := jp.Process("utf-8", true, rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []string
+	for _, req := range reqs {
+		links = append(links, req.URL.String())
+	}
+	return links
+}
+
+//go test -v -run=Test_JsonProcesser
+func Test_JsonProcesser(t *testing.T) {
+	body := `{"data": {"items": [{"url": "/a"}, {"url": "http://cdn.com/b"}, {"url": null}]},
+"links": {"next": "/api?page=2"}, "meta": {"cursor": "abc", "pages": 3}}`
+
+	jp := NewJsonProcesser(OptionJsonProcesserItems("$.data.items[*].url"), OptionJsonProcesserNext("links.next"))
+	links := jsonProcess(t, jp, "http://example.com/api", "", body)
+	//其他host的条目不跟进
+	expected := []string{"http://example.com/a", "http://example.com/api?page=2"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("next url: expected %v, got %v", expected, links)
+	}
+
+	jp = NewJsonProcesser(OptionJsonProcesserCursor("meta.cursor", "after"))
+	links = jsonProcess(t, jp, "http://example.com/api?limit=10", "", body)
+	expected = []string{"http://example.com/api?after=abc&limit=10"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("cursor: expected %v, got %v", expected, links)
+	}
+	if links = jsonProcess(t, jp, "http://example.com/api?after=abc", "", body); len(links) != 0 {
+		t.Errorf("cursor unchanged: expected no next page, got %v", links)
+	}
+
+	jp = NewJsonProcesser(OptionJsonProcesserItems("data.items.url"), OptionJsonProcesserPage("p", "meta.pages"))
+	links = jsonProcess(t, jp, "http://example.com/api?p=2", "", body)
+	if links[len(links)-1] != "http://example.com/api?p=3" {
+		t.Errorf("page: unexpected %v", links)
+	}
+	if links = jsonProcess(t, jp, "http://example.com/api?p=3", "", body); len(links) != 1 {
+		t.Errorf("page: expected last page, got %v", links)
+	}
+	jp = NewJsonProcesser(OptionJsonProcesserItems("data.items.url"), OptionJsonProcesserPage("p", ""))
+	if links = jsonProcess(t, jp, "http://example.com/api", "", `{"data": {"items": []}}`); len(links) != 0 {
+		t.Errorf("page: expected stop on empty page, got %v", links)
+	}
+	//没有条目路径时根据文档中是否有非空数组翻页
+	jp = NewJsonProcesser(OptionJsonProcesserPage("p", ""))
+	links = jsonProcess(t, jp, "http://example.com/api?p=2", "", `[{"id": 1}]`)
+	if !reflect.DeepEqual(links, []string{"http://example.com/api?p=3"}) {
+		t.Errorf("page without items path: unexpected %v", links)
+	}
+	if links = jsonProcess(t, jp, "http://example.com/api?p=3", "", `{"data": [], "total": 2}`); len(links) != 0 {
+		t.Errorf("page without items path: expected stop on empty page, got %v", links)
+	}
+
+	jp = NewJsonProcesser()
+	links = jsonProcess(t, jp, "http://example.com/api", `<http://example.com/api?page=1>; rel="prev first", <http://example.com/api?page=3>; rel="next"`, `[]`)
+	expected = []string{"http://example.com/api?page=3"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("link header: expected %v, got %v", expected, links)
+	}
+}
+
+//go test -v -run=Test_ParseLinkHeader
+func Test_ParseLinkHeader(t *testing.T) {
+	links := ParseLinkHeader([]string{`<https://a.com/x?a=1,2>; title="a, b"; rel="next"`, `</prev>;rel=prev`})
+	expected := map[string][]string{"next": {"https://a.com/x?a=1,2"}, "prev": {"/prev"}}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %v, got %v", expected, links)
+	}
+}
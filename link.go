@@ -0,0 +1,133 @@
+package spider
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//链接未被跟进的原因
+const (
+	LinkReasonNofollow  = "nofollow"        //rel="nofollow"
+	LinkReasonRobots    = "robots nofollow" //meta robots或X-Robots-Tag
+	LinkReasonExternal  = "external"
+	LinkReasonInvalid   = "invalid url"
+	LinkReasonDuplicate = "duplicate" //同一页面中已经出现过
+	LinkReasonMaxDepth  = "max depth" //超过路由的深度限制
+)
+
+//页面中发现的一个链接, 跟进时与对应的CrawlRequest.Link相同
+type Link struct {
+	Source   string   `json:"source"`
+	Target   string   `json:"target"`
+	Text     string   `json:"text,omitempty"`
+	Title    string   `json:"title,omitempty"`
+	Rel      []string `json:"rel,omitempty"`
+	Tag      string   `json:"tag,omitempty"`
+	Attr     string   `json:"attr,omitempty"`
+	Followed bool     `json:"followed"`
+	Reason   string   `json:"reason,omitempty"`
+}
+
+func (link *Link) filter(reason string) {
+	link.Followed = false
+	link.Reason = reason
+}
+
+//可选接口, 返回页面中发现的所有链接, 包括没有跟进的链接
+//没有实现的Processer, Spider按返回的请求生成Link
+type LinkProcesser interface {
+	Processer
+	ProcessLinks(string, bool, *http.Response) ([]*CrawlRequest, []*Link, error)
+}
+
+//按出现顺序记录页面中的链接, 同一个url只跟进一次
+type linkCollector struct {
+	source string //页面url, 只跟进与其同host的链接
+	base   string //解析相对链接的base, 可能来自<base href>
+	seen   map[string]bool
+	links  []*Link
+}
+
+func newLinkCollector(source, base string) *linkCollector {
+	return &linkCollector{source: source, base: base, seen: make(map[string]bool)}
+}
+
+//raw相对于base解析, 与source不同host、link中已有Reason时只记录不跟进, raw为空时忽略
+func (lc *linkCollector) add(raw string, link *Link) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return
+	}
+	link.Source = lc.source
+	link.Target = raw
+	lc.links = append(lc.links, link)
+
+	subU, err := url.Parse(raw)
+	if err != nil {
+		link.filter(LinkReasonInvalid)
+		return
+	}
+	if baseU, err := url.Parse(lc.base); err == nil {
+		link.Target = baseU.ResolveReference(subU).String()
+	}
+	if link.Reason != "" {
+		return
+	}
+
+	merged := scopeUrl(lc.source, lc.base, raw)
+	switch {
+	case merged == "":
+		link.filter(LinkReasonExternal)
+	case lc.seen[merged]:
+		link.filter(LinkReasonDuplicate)
+	default:
+		lc.seen[merged] = true
+		link.Target = merged
+		link.Followed = true
+	}
+}
+
+func (lc *linkCollector) followed() []*Link {
+	var links []*Link
+	for _, link := range lc.links {
+		if link.Followed {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+//跟进的链接生成子请求
+func linkRequests(parent *CrawlRequest, refer string, links []*Link, source string) []*CrawlRequest {
+	var reqs []*CrawlRequest
+	for _, link := range links {
+		req, err := http.NewRequest(http.MethodGet, link.Target, nil)
+		if err != nil {
+			link.filter(LinkReasonInvalid)
+			continue
+		}
+		setReferer(req, refer)
+		child := parent.Child(req, source)
+		child.Link = link
+		reqs = append(reqs, child)
+	}
+	return reqs
+}
+
+//防防盗链, 子请求带上来源页面
+func setReferer(req *http.Request, page string) {
+	req.Header.Set("Referer", page)
+}
+
+func relValues(rel string) []string {
+	values := strings.Fields(strings.ToLower(rel))
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,327 @@
+package spider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	LinkCheckerConcuDefault   = 10
+	LinkCheckerTimeoutDefault = 10 * time.Second
+
+	redirectMax = 10
+)
+
+//链接失效的类型
+const (
+	FailureStatus       = "status" //4xx和5xx
+	FailureDNS          = "dns"
+	FailureTimeout      = "timeout"
+	FailureRedirectLoop = "redirect loop"
+	FailureInvalid      = "invalid url"
+	FailureRequest      = "request" //连接被拒绝等其他错误
+)
+
+var ErrRedirectLoop = errors.New("redirect loop")
+
+//可用于OptionSpiderRequestCheckRedirect, 重定向回已经访问过的url时返回ErrRedirectLoop
+func CheckRedirectLoop(req *http.Request, via []*http.Request) error {
+	for _, prev := range via {
+		if prev.URL.String() == req.URL.String() {
+			return ErrRedirectLoop
+		}
+	}
+	if len(via) >= redirectMax {
+		return fmt.Errorf("stopped after %d redirects", redirectMax)
+	}
+	return nil
+}
+
+func requestFailure(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrRedirectLoop):
+		return FailureRedirectLoop
+	case errors.As(err, &dnsErr):
+		return FailureDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	}
+	return FailureRequest
+}
+
+type OptionLinkChecker func(*LinkChecker)
+
+//检查外部链接的client, 缺省使用CheckRedirectLoop
+func OptionLinkCheckerClient(client *http.Client) OptionLinkChecker {
+	return func(lc *LinkChecker) {
+		lc.client = client
+	}
+}
+
+func OptionLinkCheckerConcu(concu int) OptionLinkChecker {
+	return func(lc *LinkChecker) {
+		if concu > 0 {
+			lc.concu = concu
+		}
+	}
+}
+
+//检查外部链接时是否直接使用GET, 缺省先HEAD, 服务端不支持HEAD时再GET
+func OptionLinkCheckerGet(get bool) OptionLinkChecker {
+	return func(lc *LinkChecker) {
+		lc.get = get
+	}
+}
+
+//引用失效链接的页面和锚文本
+type LinkReferrer struct {
+	Page string `json:"page"`
+	Text string `json:"text,omitempty"`
+}
+
+type BrokenLink struct {
+	URL        string          `json:"url"`
+	Failure    string          `json:"failure"`
+	StatusCode int             `json:"status_code,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	External   bool            `json:"external"`
+	Referrers  []*LinkReferrer `json:"referrers,omitempty"`
+}
+
+type LinkReport struct {
+	Checked int           `json:"checked"`
+	Broken  []*BrokenLink `json:"broken"`
+}
+
+//spider递归抓取内部链接, 结束后对没有跟进的链接只做HEAD或GET检查, 不再递归
+//与种子请求同host的链接为内部链接, 其他为外部链接
+//spider需要预先加入种子请求, 没有设置CheckRedirect时使用CheckRedirectLoop
+type LinkChecker struct {
+	spider *Spider
+	client *http.Client
+	concu  int
+	get    bool
+}
+
+func NewLinkChecker(spider *Spider, options ...OptionLinkChecker) *LinkChecker {
+	lc := &LinkChecker{
+		spider: spider,
+		concu:  LinkCheckerConcuDefault,
+	}
+	for _, option := range options {
+		option(lc)
+	}
+	if spider.checkRedirect == nil {
+		spider.checkRedirect = CheckRedirectLoop
+	}
+	if lc.client == nil {
+		timeout := spider.timeout
+		if timeout == 0 {
+			timeout = LinkCheckerTimeoutDefault
+		}
+		lc.client = &http.Client{CheckRedirect: CheckRedirectLoop, Timeout: timeout}
+	}
+	return lc
+}
+
+type linkTarget struct {
+	referrers   []*LinkReferrer
+	external    bool
+	invalid     bool
+	unsupported bool //mailto:, tel:, javascript:等非http链接
+}
+
+//运行spider并检查所有链接, 返回的失效链接按url排序
+func (lc *LinkChecker) Check() *LinkReport {
+	results := lc.spider.Run().Result()
+
+	//和种子请求同host的链接为内部链接, 由spider递归抓取
+	seeds := make(map[string]bool)
+	for _, result := range results {
+		if result.Req != nil && result.Parent == "" {
+			seeds[hostKey(result.Req.URL)] = true
+		}
+	}
+	targets := make(map[string]*linkTarget)
+	target := func(rawurl string) *linkTarget {
+		if targets[rawurl] == nil {
+			targets[rawurl] = &linkTarget{}
+			if u, err := url.Parse(rawurl); err == nil {
+				targets[rawurl].external = !seeds[hostKey(u)]
+				targets[rawurl].unsupported = u.Scheme != "http" && u.Scheme != "https"
+			}
+		}
+		return targets[rawurl]
+	}
+	for _, result := range results {
+		for _, link := range result.Links {
+			t := target(link.Target)
+			t.referrers = append(t.referrers, &LinkReferrer{Page: link.Source, Text: link.Text})
+			if link.Reason == LinkReasonInvalid {
+				t.invalid = true
+			}
+		}
+	}
+	//种子请求没有引用页面
+	for key, result := range results {
+		if result.Req != nil {
+			key = result.Req.URL.String()
+		}
+		target(key)
+	}
+
+	report := &LinkReport{Checked: len(targets)}
+	var unchecked []string
+	for url, t := range targets {
+		result, ok := results[url]
+		switch {
+		case t.invalid:
+			report.Broken = append(report.Broken, &BrokenLink{URL: url, Failure: FailureInvalid})
+		case t.unsupported:
+			//无法用http检查, 计入检查数但不算失效
+		case ok && result.StatusCode == 0 && result.Failure == "":
+			//host不健康或预检被拒绝, spider没有发出请求, 需要单独检查
+			unchecked = append(unchecked, url)
+		case ok:
+			if broken := brokenResult(url, result); broken != nil {
+				report.Broken = append(report.Broken, broken)
+			}
+		default:
+			unchecked = append(unchecked, url)
+		}
+	}
+	report.Broken = append(report.Broken, lc.checkAll(unchecked)...)
+
+	for _, broken := range report.Broken {
+		t := targets[broken.URL]
+		broken.External = t.external
+		broken.Referrers = t.referrers
+		sort.Slice(broken.Referrers, func(i, j int) bool {
+			return broken.Referrers[i].Page < broken.Referrers[j].Page
+		})
+	}
+	sort.Slice(report.Broken, func(i, j int) bool {
+		return report.Broken[i].URL < report.Broken[j].URL
+	})
+	return report
+}
+
+func brokenResult(url string, result *Result) *BrokenLink {
+	if result.Failure != "" {
+		return &BrokenLink{URL: url, Failure: result.Failure, Error: result.Error}
+	}
+	if result.StatusCode >= http.StatusBadRequest {
+		return &BrokenLink{URL: url, Failure: FailureStatus, StatusCode: result.StatusCode}
+	}
+	return nil
+}
+
+func (lc *LinkChecker) checkAll(urls []string) []*BrokenLink {
+	var brokens []*BrokenLink
+	var mutex sync.Mutex
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, lc.concu)
+	for _, url := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(url string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if broken := lc.check(url); broken != nil {
+				mutex.Lock()
+				brokens = append(brokens, broken)
+				mutex.Unlock()
+			}
+		}(url)
+	}
+	wg.Wait()
+	return brokens
+}
+
+func (lc *LinkChecker) check(url string) *BrokenLink {
+	method := http.MethodHead
+	if lc.get {
+		method = http.MethodGet
+	}
+	code, err := lc.request(method, url)
+	//不支持HEAD的服务端
+	if err == nil && method == http.MethodHead &&
+		(code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented) {
+		code, err = lc.request(http.MethodGet, url)
+	}
+
+	switch {
+	case err != nil:
+		return &BrokenLink{URL: url, Failure: requestFailure(err), Error: err.Error()}
+	case code >= http.StatusBadRequest:
+		return &BrokenLink{URL: url, Failure: FailureStatus, StatusCode: code}
+	}
+	return nil
+}
+
+func (lc *LinkChecker) request(method, url string) (int, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	for k, vs := range lc.spider.defaultHeader {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	rsp, err := lc.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(rsp.Body, 1<<20))
+	return rsp.StatusCode, nil
+}
+
+func (report *LinkReport) WriteJson(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+//每个失效链接一段, 列出引用它的页面
+func (report *LinkReport) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "checked %d links, %d broken\n", report.Checked, len(report.Broken)); err != nil {
+		return err
+	}
+	for _, broken := range report.Broken {
+		reason := broken.Failure
+		if broken.StatusCode != 0 {
+			reason = fmt.Sprintf("%s %d", reason, broken.StatusCode)
+		}
+		if broken.Error != "" {
+			reason = fmt.Sprintf("%s: %s", reason, broken.Error)
+		}
+		scope := "internal"
+		if broken.External {
+			scope = "external"
+		}
+		if _, err := fmt.Fprintf(w, "\n%s [%s] %s\n", broken.URL, scope, reason); err != nil {
+			return err
+		}
+		for _, referrer := range broken.Referrers {
+			if _, err := fmt.Fprintf(w, "  <- %s %q\n", referrer.Page, referrer.Text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
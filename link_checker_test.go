@@ -0,0 +1,168 @@
+package spider
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//go test -v -run=Test_LinkChecker
+func Test_LinkChecker(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/get-only":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/slow":
+			time.Sleep(time.Second)
+		}
+	}))
+	defer external.Close()
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	refused := "http://" + listener.Addr().String() + "/"
+	listener.Close()
+
+	var internal *httptest.Server
+	internal = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<a href="/ok">ok</a><a href="/missing">missing page</a><a href="/loop">loop</a>
+<a href="%s/get-only">get only</a><a href="%s/gone">gone</a><a href="%s/slow">slow</a><a href="%s">refused</a>
+<a href="%s/docs/guide.html">guide</a>`,
+				external.URL, external.URL, external.URL, refused, internal.URL)
+		case "/docs/guide.html":
+			//同host不同路径的绝对链接也要递归
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/broken.html">broken</a>`)
+		case "/ok":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/missing">again</a>`)
+		case "/loop":
+			http.Redirect(w, r, internal.URL+"/loop", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer internal.Close()
+
+	spider := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+		OptionSpiderRequestTimeout(200*time.Millisecond),
+	)
+	seed, _ := http.NewRequest(http.MethodGet, internal.URL+"/", nil)
+	spider.AddRequest(seed)
+	report := NewLinkChecker(spider).Check()
+
+	expected := map[string]string{
+		internal.URL + "/missing":     FailureStatus,
+		internal.URL + "/loop":        FailureRedirectLoop,
+		internal.URL + "/broken.html": FailureStatus,
+		external.URL + "/gone":        FailureStatus,
+		external.URL + "/slow":        FailureTimeout,
+		refused:                       FailureRequest,
+	}
+	if len(report.Broken) != len(expected) {
+		t.Errorf("expected %d broken links, got %d", len(expected), len(report.Broken))
+	}
+	for _, broken := range report.Broken {
+		if expected[broken.URL] != broken.Failure {
+			t.Errorf("%s: expected %q, got %q", broken.URL, expected[broken.URL], broken.Failure)
+		}
+		if broken.URL == internal.URL+"/missing" &&
+			(broken.External || len(broken.Referrers) != 2 || broken.Referrers[0].Text != "missing page") {
+			t.Errorf("unexpected referrers %+v", broken.Referrers)
+		}
+		if broken.URL == internal.URL+"/broken.html" &&
+			(broken.External || len(broken.Referrers) != 1 || broken.Referrers[0].Page != internal.URL+"/docs/guide.html") {
+			t.Errorf("links behind absolute internal links should be checked, got %+v", broken)
+		}
+		if broken.URL == external.URL+"/gone" && (!broken.External || broken.StatusCode != http.StatusGone) {
+			t.Errorf("unexpected external link %+v", broken)
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := report.WriteText(buffer); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(buffer.String(), internal.URL+"/missing [internal] status 404\n  <- "+internal.URL+"/ \"missing page\"") {
+		t.Errorf("unexpected text report:\n%s", buffer.String())
+	}
+	buffer.Reset()
+	if err := report.WriteJson(buffer); err != nil || !strings.Contains(buffer.String(), `"failure": "redirect loop"`) {
+		t.Errorf("unexpected json report:\n%s", buffer.String())
+	}
+
+	if mergeUrl("http://h/", "http://h.evil.com/x") != "" || mergeUrl("http://h/a/", "HTTPS://H/b") == "" {
+		t.Errorf("absolute links should be compared by host")
+	}
+	if mergeUrl("http://h/", "//cdn.other.test/x.js") != "" || mergeUrl("http://h/a/", "//h/b") != "http://h/b" {
+		t.Errorf("protocol-relative links should be compared by host")
+	}
+
+	dnsErr := &url.Error{Op: "Get", URL: "http://nowhere.invalid/", Err: &net.DNSError{Err: "no such host", Name: "nowhere.invalid"}}
+	if requestFailure(dnsErr) != FailureDNS {
+		t.Errorf("dns errors should be classified")
+	}
+}
+
+//go test -v -run=Test_LinkCheckerUnverified
+func Test_LinkCheckerUnverified(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="mailto:a@example.com">mail</a><a href="tel:+123">tel</a><a href="javascript:void(0)">js</a>
+<a href="/a">a</a><a href="/b">b</a>`)
+			return
+		}
+		//第一个子请求使host被标记为不健康, 之后的请求返回404
+		mutex.Lock()
+		requests++
+		first := requests == 1
+		mutex.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	spider := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+		OptionSpiderConcu(1),
+		OptionSpiderRetry(0),
+		OptionSpiderUnhealthyThreshold(1),
+	)
+	seed, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	report := NewLinkChecker(spider.AddRequest(seed)).Check()
+
+	//spider没有请求的链接需要单独检查, 不能当作正常
+	statuses := map[string]int{}
+	for _, broken := range report.Broken {
+		statuses[broken.URL] = broken.StatusCode
+	}
+	if len(statuses) != 2 || statuses[server.URL+"/a"]+statuses[server.URL+"/b"] != http.StatusServiceUnavailable+http.StatusNotFound {
+		t.Errorf("links skipped by an unhealthy host should be checked: %+v", statuses)
+	}
+	//非http链接计入检查数, 但不算失效
+	if report.Checked != 6 {
+		t.Errorf("expected 6 checked links, got %d", report.Checked)
+	}
+}
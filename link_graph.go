@@ -0,0 +1,356 @@
+package spider
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const (
+	PageRankDampingDefault    = 0.85
+	PageRankIterationsDefault = 100
+
+	pageRankTolerance = 1e-9
+)
+
+type LinkNode struct {
+	URL        string
+	Crawled    bool //发现但没有抓取或请求失败的页面为false
+	StatusCode int
+	Depth      uint
+	InDegree   int
+	OutDegree  int
+}
+
+//页面之间的有向图, 边来自Result.Subs, 重复的边和指向自身的边被忽略
+type LinkGraph struct {
+	nodes []*LinkNode
+	index map[string]int
+	out   [][]int
+	edges map[[2]int]bool
+}
+
+func NewLinkGraph(results map[string]*Result) *LinkGraph {
+	lg := &LinkGraph{
+		index: make(map[string]int),
+		edges: make(map[[2]int]bool),
+	}
+	//按url排序, 保证结果和导出稳定
+	keys := make([]string, 0, len(results))
+	for key := range results {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lg.AddResult(key, results[key])
+	}
+	return lg
+}
+
+//运行中的Spider得到的是当前的快照, 只包含已经处理完的请求
+func (spider *Spider) LinkGraph() *LinkGraph {
+	spider.mutex.RLock()
+	defer spider.mutex.RUnlock()
+	return NewLinkGraph(spider.results)
+}
+
+//key为Spider.Result的key作为节点
+//非GET请求的key带有方法和body摘要, 与同url的GET请求是不同的节点, 互不覆盖
+func (lg *LinkGraph) AddResult(key string, result *Result) {
+	node := lg.nodes[lg.node(key)]
+	//没有收到响应的请求(host不健康、预检被拒绝、请求失败)不算抓取过
+	node.Crawled = result.StatusCode != 0
+	node.StatusCode = result.StatusCode
+	node.Depth = result.Depth
+	for _, sub := range result.Subs {
+		lg.AddEdge(key, sub)
+	}
+}
+
+func (lg *LinkGraph) AddEdge(from, to string) {
+	i, j := lg.node(from), lg.node(to)
+	if i == j || lg.edges[[2]int{i, j}] {
+		return
+	}
+	lg.edges[[2]int{i, j}] = true
+	lg.out[i] = append(lg.out[i], j)
+	lg.nodes[i].OutDegree++
+	lg.nodes[j].InDegree++
+}
+
+func (lg *LinkGraph) node(url string) int {
+	if i, ok := lg.index[url]; ok {
+		return i
+	}
+	lg.index[url] = len(lg.nodes)
+	lg.nodes = append(lg.nodes, &LinkNode{URL: url})
+	lg.out = append(lg.out, nil)
+	return len(lg.nodes) - 1
+}
+
+//按加入顺序
+func (lg *LinkGraph) Nodes() []*LinkNode {
+	return lg.nodes
+}
+
+func (lg *LinkGraph) Node(url string) *LinkNode {
+	if i, ok := lg.index[url]; ok {
+		return lg.nodes[i]
+	}
+	return nil
+}
+
+//没有其他页面链接到的已抓取页面, 种子页面通常也在其中
+func (lg *LinkGraph) Orphans() []string {
+	var orphans []string
+	for _, node := range lg.nodes {
+		if node.Crawled && node.InDegree == 0 {
+			orphans = append(orphans, node.URL)
+		}
+	}
+	return orphans
+}
+
+//没有出链的页面的分值平均分给所有页面, 变化小于容差或达到iterations时结束
+func (lg *LinkGraph) PageRank(damping float64, iterations int) map[string]float64 {
+	n := len(lg.nodes)
+	ranks := make(map[string]float64, n)
+	if n == 0 {
+		return ranks
+	}
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	for iter := 0; iter < iterations; iter++ {
+		dangling := 0.0
+		for i := range lg.nodes {
+			if len(lg.out[i]) == 0 {
+				dangling += rank[i]
+			}
+		}
+		base := (1-damping)/float64(n) + damping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for i, targets := range lg.out {
+			share := damping * rank[i] / float64(len(targets))
+			for _, j := range targets {
+				next[j] += share
+			}
+		}
+
+		delta := 0.0
+		for i := range rank {
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if delta < pageRankTolerance {
+			break
+		}
+	}
+	for i, node := range lg.nodes {
+		ranks[node.URL] = rank[i]
+	}
+	return ranks
+}
+
+//Tarjan算法, 用显式的栈避免深度很大的图递归溢出
+//按大小降序, 每个分量内按url排序
+func (lg *LinkGraph) StronglyConnectedComponents() [][]string {
+	n := len(lg.nodes)
+	index := make([]int, n)
+	low := make([]int, n)
+	onStack := make([]bool, n)
+	for i := range index {
+		index[i] = -1
+	}
+	var stack []int
+	var components [][]string
+	counter := 0
+
+	type frame struct {
+		node int
+		edge int
+	}
+	for root := 0; root < n; root++ {
+		if index[root] >= 0 {
+			continue
+		}
+		calls := []frame{{node: root}}
+		index[root], low[root] = counter, counter
+		counter++
+		stack = append(stack, root)
+		onStack[root] = true
+
+		for len(calls) > 0 {
+			top := &calls[len(calls)-1]
+			v := top.node
+			if top.edge < len(lg.out[v]) {
+				w := lg.out[v][top.edge]
+				top.edge++
+				if index[w] < 0 {
+					index[w], low[w] = counter, counter
+					counter++
+					stack = append(stack, w)
+					onStack[w] = true
+					calls = append(calls, frame{node: w})
+				} else if onStack[w] && index[w] < low[v] {
+					low[v] = index[w]
+				}
+				continue
+			}
+
+			calls = calls[:len(calls)-1]
+			if len(calls) > 0 {
+				parent := calls[len(calls)-1].node
+				if low[v] < low[parent] {
+					low[parent] = low[v]
+				}
+			}
+			if low[v] != index[v] {
+				continue
+			}
+			var component []string
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, lg.nodes[w].URL)
+				if w == v {
+					break
+				}
+			}
+			sort.Strings(component)
+			components = append(components, component)
+		}
+	}
+	sort.SliceStable(components, func(i, j int) bool {
+		if len(components[i]) != len(components[j]) {
+			return len(components[i]) > len(components[j])
+		}
+		return components[i][0] < components[j][0]
+	})
+	return components
+}
+
+//每行一条边: source,target
+func (lg *LinkGraph) WriteCsv(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"source", "target"})
+	for i, targets := range lg.out {
+		for _, j := range targets {
+			writer.Write([]string{lg.nodes[i].URL, lg.nodes[j].URL})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (lg *LinkGraph) WriteDot(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph links {"); err != nil {
+		return err
+	}
+	for i, node := range lg.nodes {
+		style := ""
+		if !node.Crawled {
+			style = ", style=dashed"
+		}
+		if _, err := fmt.Fprintf(w, "  n%d [label=%s%s];\n", i, strconv.Quote(node.URL), style); err != nil {
+			return err
+		}
+	}
+	for i, targets := range lg.out {
+		for _, j := range targets {
+			if _, err := fmt.Fprintf(w, "  n%d -> n%d;\n", i, j); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+type graphmlKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type graphmlDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   graphmlGraph `xml:"graph"`
+}
+
+type graphmlGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphmlNode `xml:"node"`
+	Edges       []graphmlEdge `xml:"edge"`
+}
+
+func (lg *LinkGraph) WriteGraphml(w io.Writer) error {
+	doc := graphmlDocument{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphmlKey{
+			{ID: "url", For: "node", Name: "url", Type: "string"},
+			{ID: "crawled", For: "node", Name: "crawled", Type: "boolean"},
+			{ID: "status", For: "node", Name: "status_code", Type: "int"},
+			{ID: "depth", For: "node", Name: "depth", Type: "int"},
+		},
+		Graph: graphmlGraph{ID: "links", EdgeDefault: "directed"},
+	}
+	for i, node := range lg.nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphmlNode{
+			ID: "n" + strconv.Itoa(i),
+			Data: []graphmlData{
+				{Key: "url", Value: node.URL},
+				{Key: "crawled", Value: strconv.FormatBool(node.Crawled)},
+				{Key: "status", Value: strconv.Itoa(node.StatusCode)},
+				{Key: "depth", Value: strconv.FormatUint(uint64(node.Depth), 10)},
+			},
+		})
+	}
+	for i, targets := range lg.out {
+		for _, j := range targets {
+			doc.Graph.Edges = append(doc.Graph.Edges, graphmlEdge{
+				Source: "n" + strconv.Itoa(i),
+				Target: "n" + strconv.Itoa(j),
+			})
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
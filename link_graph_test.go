@@ -0,0 +1,125 @@
+package spider
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//go test -v -run=Test_LinkGraph
+func Test_LinkGraph(t *testing.T) {
+	results := map[string]*Result{
+		"http://example.com/a": {StatusCode: 200, Subs: []string{"http://example.com/b", "http://example.com/d", "http://example.com/b"}},
+		"http://example.com/b": {StatusCode: 200, Depth: 1, Subs: []string{"http://example.com/c", "http://example.com/b"}},
+		"http://example.com/c": {StatusCode: 200, Depth: 2, Subs: []string{"http://example.com/a", "http://example.com/x"}},
+		"http://example.com/d": {StatusCode: 404, Depth: 1},
+		"http://example.com/e": {StatusCode: 200},
+		"http://example.com/f": {Error: "unhealthy host"},
+	}
+	lg := NewLinkGraph(results)
+
+	b := lg.Node("http://example.com/b")
+	if b == nil || b.InDegree != 1 || b.OutDegree != 1 || b.Depth != 1 {
+		t.Errorf("duplicate edges and self loops should be ignored, got %+v", b)
+	}
+	if x := lg.Node("http://example.com/x"); x == nil || x.Crawled || x.InDegree != 1 {
+		t.Errorf("uncrawled targets should be nodes, got %+v", x)
+	}
+	if f := lg.Node("http://example.com/f"); f == nil || f.Crawled {
+		t.Errorf("requests without response should not be crawled, got %+v", f)
+	}
+	if orphans := lg.Orphans(); !reflect.DeepEqual(orphans, []string{"http://example.com/e"}) {
+		t.Errorf("unexpected orphans %v", orphans)
+	}
+
+	components := lg.StronglyConnectedComponents()
+	if len(components) != 5 || !reflect.DeepEqual(components[0], []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}) {
+		t.Errorf("unexpected components %v", components)
+	}
+
+	ranks := lg.PageRank(PageRankDampingDefault, PageRankIterationsDefault)
+	sum := 0.0
+	for _, rank := range ranks {
+		sum += rank
+	}
+	if math.Abs(sum-1) > 1e-6 || ranks["http://example.com/a"] <= ranks["http://example.com/e"] {
+		t.Errorf("unexpected page ranks %v", ranks)
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := lg.WriteCsv(buffer); err != nil {
+		t.Error(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 6 || lines[1] != "http://example.com/a,http://example.com/b" {
+		t.Errorf("unexpected csv %q", lines)
+	}
+
+	buffer.Reset()
+	if err := lg.WriteDot(buffer); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(buffer.String(), `n0 -> n1;`) || !strings.Contains(buffer.String(), `"http://example.com/x", style=dashed`) ||
+		!strings.Contains(buffer.String(), `"http://example.com/f", style=dashed`) {
+		t.Errorf("unexpected dot %s", buffer.String())
+	}
+
+	buffer.Reset()
+	if err := lg.WriteGraphml(buffer); err != nil {
+		t.Error(err)
+	}
+	var doc graphmlDocument
+	if err := xml.Unmarshal(buffer.Bytes(), &doc); err != nil || len(doc.Graph.Nodes) != 7 || len(doc.Graph.Edges) != 5 {
+		t.Errorf("unexpected graphml %s, err: %v", buffer.String(), err)
+	}
+}
+
+//go test -v -run=Test_LinkGraphMethod
+func Test_LinkGraphMethod(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "http://example.com/x", nil)
+	post, _ := http.NewRequest(http.MethodPost, "http://example.com/x", strings.NewReader("a=1"))
+	results := map[string]*Result{
+		requestKey(get):  {Req: NewCrawlRequest(get), StatusCode: 200, Subs: []string{"http://example.com/y"}},
+		requestKey(post): {Req: NewCrawlRequest(post), StatusCode: 500, Depth: 1},
+	}
+	lg := NewLinkGraph(results)
+
+	if len(lg.Nodes()) != 3 {
+		t.Errorf("GET and POST of the same url should be separate nodes: %+v", lg.Nodes())
+	}
+	if node := lg.Node("http://example.com/x"); node == nil || node.StatusCode != 200 || node.Depth != 0 || node.OutDegree != 1 {
+		t.Errorf("GET node should not be overwritten by POST, got %+v", node)
+	}
+	if node := lg.Node(requestKey(post)); node == nil || node.StatusCode != 500 || node.Depth != 1 {
+		t.Errorf("POST node should keep its result key, got %+v", node)
+	}
+}
+
+//go test -v -run=Test_SpiderLinkGraph
+func Test_SpiderLinkGraph(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="/1">1</a><a href="/2">2</a><a href="/3">3</a>`)
+	}))
+	defer server.Close()
+
+	spider := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+	)
+	//运行中取快照
+	spider.OnResponse(func(ctx *CallbackContext) {
+		spider.LinkGraph()
+	})
+	seed, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	lg := spider.AddRequest(seed).Run().LinkGraph()
+	if len(lg.Nodes()) != 4 || len(lg.Orphans()) != 1 {
+		t.Errorf("unexpected graph %+v", lg.Nodes())
+	}
+}
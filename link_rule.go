@@ -0,0 +1,116 @@
+package spider
+
+import (
+	"strings"
+	"unicode"
+)
+
+//一个选择器对应多个属性, 同一元素的每个属性都可以产生链接
+//设置XPath时忽略Selector: 选中属性时取属性值, 选中元素时取Attrs, Attrs为空时取元素文本
+type LinkRule struct {
+	Selector string
+	XPath    string
+	Attrs    []string
+	//属性值的解析方式, 为空时整个值是一个链接
+	Parse func(value string) []string
+}
+
+//懒加载常用的属性
+var lazyLoadAttrs = []string{"data-src", "data-original", "data-lazy-src", "data-lazy", "data-url"}
+
+var DefaultLinkRules = []LinkRule{
+	{Selector: "a, area, link, base", Attrs: []string{"href"}},
+	{Selector: "script, embed, track, input[type=image i]", Attrs: []string{"src"}},
+	{Selector: "img", Attrs: append([]string{"src", "lowsrc", "longdesc"}, lazyLoadAttrs...)},
+	{Selector: "frame, iframe", Attrs: append([]string{"src", "longdesc"}, lazyLoadAttrs...)},
+	{Selector: "video", Attrs: append([]string{"src", "poster"}, lazyLoadAttrs...)},
+	{Selector: "audio, source", Attrs: append([]string{"src"}, lazyLoadAttrs...)},
+	{Selector: "img, source", Attrs: []string{"srcset", "data-srcset"}, Parse: ParseLinkSrcset},
+	{Selector: "link", Attrs: []string{"imagesrcset"}, Parse: ParseLinkSrcset},
+	{Selector: "object", Attrs: []string{"data", "codebase"}},
+	{Selector: "applet", Attrs: []string{"code", "codebase", "archive"}},
+	{Selector: "blockquote, q, del, ins", Attrs: []string{"cite"}},
+	{Selector: "body, table, td, th", Attrs: []string{"background"}},
+	{Selector: "head", Attrs: []string{"profile"}},
+	{Selector: `meta[http-equiv="refresh" i]`, Attrs: []string{"content"}, Parse: ParseLinkRefresh},
+}
+
+//OptionDomProcesserSelectors使用的属性, 兼容之前的行为
+var legacyLinkAttrs = []string{"href", "src", "action", "codebase", "cite", "longdesc", "usemap", "profile"}
+
+//https://html.spec.whatwg.org/multipage/images.html#parsing-a-srcset-attribute
+func ParseLinkSrcset(value string) []string {
+	var links []string
+	for _, candidate := range parseSrcset(value) {
+		links = append(links, candidate.url)
+	}
+	return links
+}
+
+//srcset中的一项, descriptor可以为空
+type srcsetCandidate struct {
+	url        string
+	descriptor string
+}
+
+func parseSrcset(value string) []srcsetCandidate {
+	var candidates []srcsetCandidate
+	for value != "" {
+		value = strings.TrimLeftFunc(value, func(r rune) bool {
+			return unicode.IsSpace(r) || r == ','
+		})
+		if value == "" {
+			break
+		}
+
+		end := strings.IndexFunc(value, unicode.IsSpace)
+		if end < 0 {
+			end = len(value)
+		}
+		link := value[:end]
+		value = value[end:]
+
+		//url以逗号结尾时没有描述符
+		if trimmed := strings.TrimRight(link, ","); trimmed != link {
+			candidates = append(candidates, srcsetCandidate{url: trimmed})
+			continue
+		}
+
+		//跳过描述符, 括号内的逗号不算分隔
+		depth := 0
+		i := 0
+		for ; i < len(value); i++ {
+			if value[i] == '(' {
+				depth++
+			} else if value[i] == ')' && depth > 0 {
+				depth--
+			} else if value[i] == ',' && depth == 0 {
+				break
+			}
+		}
+		candidates = append(candidates, srcsetCandidate{url: link, descriptor: strings.TrimSpace(value[:i])})
+		value = value[i:]
+	}
+	return candidates
+}
+
+//<meta http-equiv="refresh" content="5; url=/next">
+func ParseLinkRefresh(value string) []string {
+	sep := strings.IndexAny(value, ";,")
+	if sep < 0 {
+		return nil
+	}
+	value = strings.TrimSpace(value[sep+1:])
+	if len(value) >= 3 && strings.EqualFold(value[:3], "url") {
+		value = strings.TrimSpace(value[3:])
+		if !strings.HasPrefix(value, "=") {
+			return nil
+		}
+		value = strings.TrimSpace(value[1:])
+	}
+	value = strings.Trim(value, `"'`)
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
@@ -0,0 +1,107 @@
+package spider
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//go test -v -run=Test_DomProcesserLinks
+func Test_DomProcesserLinks(t *testing.T) {
+	page := `<html><body style="background: url(/bg.png)">
+<a href="/a" title=" A page " rel="Next">go  to
+ a</a>
+<a href="/a">again</a>
+<a href="/sponsor" rel="sponsored nofollow">sponsor</a>
+<a href="http://other.com/">other</a>
+<a href="http://[::1">broken</a>
+<img src="/logo.png">
+</body></html>`
+	rsp := newTestResponse(t, "http://example.com/", "text/html", []byte(page))
+	reqs, links, err := NewDomProcesser().ProcessLinks("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []Link{
+		{Target: "http://example.com/a", Text: "go to a", Title: "A page", Rel: []string{"next"}, Tag: "a", Attr: "href", Followed: true},
+		{Target: "http://example.com/a", Text: "again", Tag: "a", Attr: "href", Reason: LinkReasonDuplicate},
+		{Target: "http://example.com/sponsor", Text: "sponsor", Rel: []string{"sponsored", "nofollow"}, Tag: "a", Attr: "href", Reason: LinkReasonNofollow},
+		{Target: "http://other.com/", Text: "other", Tag: "a", Attr: "href", Reason: LinkReasonExternal},
+		{Target: "http://[::1", Text: "broken", Tag: "a", Attr: "href", Reason: LinkReasonInvalid},
+		{Target: "http://example.com/logo.png", Tag: "img", Attr: "src", Followed: true},
+		{Target: "http://example.com/bg.png", Tag: "body", Attr: "style", Followed: true},
+	}
+	if len(links) != len(expected) {
+		t.Errorf("expected %d links, got %d", len(expected), len(links))
+		return
+	}
+	for i, link := range links {
+		expected[i].Source = "http://example.com/"
+		if !reflect.DeepEqual(*link, expected[i]) {
+			t.Errorf("link %d: expected %+v, got %+v", i, expected[i], *link)
+		}
+	}
+	if len(reqs) != 3 || reqs[0].Link != links[0] || reqs[2].Source != SourceStyle {
+		t.Errorf("followed links should carry their records: %v", reqs)
+	}
+	if refer := reqs[0].Header.Get("Referer"); refer != "http://example.com/" {
+		t.Errorf("child request should carry Referer, got %q", refer)
+	}
+
+	//nofollow的页面记录链接但不跟进
+	rsp = newTestResponse(t, "http://example.com/", "text/html", []byte(`<meta name="robots" content="nofollow"><a href="/a">a</a>`))
+	reqs, links, err = NewDomProcesser().ProcessLinks("utf-8", true, rsp)
+	if err != nil || len(reqs) != 0 || len(links) != 1 || links[0].Reason != LinkReasonRobots {
+		t.Errorf("unexpected robots nofollow result %v %v %v", reqs, links, err)
+	}
+}
+
+//go test -v -run=Test_SpiderLinkExporter
+func Test_SpiderLinkExporter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="/deep/1">1</a><a href="/deep/2" rel="nofollow">2</a>`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	exporter, err := NewCsvExporter(dir, "links", OptionExporterFields("source", "target", "text", "followed", "reason"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	router := NewProcesserRouter()
+	router.Handle([]Processer{NewDomProcesser()}, OptionProcesserRoutePath("/deep/{n}"), OptionProcesserRouteMaxDepth(1))
+	router.Handle([]Processer{NewDomProcesser()})
+	spider := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+		OptionSpiderProcesserRouter(router),
+		OptionSpiderLinkExporter(exporter),
+	)
+	seed, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	result := spider.AddRequest(seed).Run().Result()
+
+	//页面/deep/1的深度为1, 它发现的链接超过路由的深度限制
+	links := result[server.URL+"/deep/1"].Links
+	if len(links) != 2 || links[0].Reason != LinkReasonMaxDepth || links[1].Reason != LinkReasonNofollow {
+		t.Errorf("unexpected links %+v", links)
+	}
+	files, _ := exporter.Files()
+	if len(files) != 1 {
+		t.Errorf("expected 1 exported file, got %v", files)
+		return
+	}
+	data, _ := ioutil.ReadFile(files[0])
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	row := server.URL + "/," + server.URL + "/deep/1,1,true,"
+	if len(lines) != 5 || lines[0] != "source,target,text,followed,reason" || !strings.Contains(string(data), row) {
+		t.Errorf("unexpected export %q", lines)
+	}
+}
@@ -1,10 +1,12 @@
 package spider
 
 const (
-	ContentTypeACC    = ".acc"
+	ContentTypeAAC    = ".aac"
 	ContentTypeABW    = ".abw"
 	ContentTypeARC    = ".arc"
+	ContentTypeATOM   = ".atom"
 	ContentTypeAVI    = ".avi"
+	ContentTypeAVIF   = ".avif"
 	ContentTypeAZW    = ".azw"
 	ContentTypeBIN    = ".bin"
 	ContentTypeBMP    = ".bmp"
@@ -18,6 +20,7 @@ const (
 	ContentTypeEOT    = ".eot"
 	ContentTypeEPUB   = ".epub"
 	ContentTypeGIF    = ".gif"
+	ContentTypeGZ     = ".gz"
 	ContentTypeHTM    = ".htm"
 	ContentTypeHTML   = ".html"
 	ContentTypeICO    = ".ico"
@@ -32,6 +35,7 @@ const (
 	ContentTypeMIDI   = ".midi"
 	ContentTypeMJS    = ".mjs"
 	ContentTypeMP3    = ".mp3"
+	ContentTypeMP4    = ".mp4"
 	ContentTypeMPEG   = ".mpeg"
 	ContentTypeMPKG   = ".mpkg"
 	ContentTypeODP    = ".odp"
@@ -46,6 +50,7 @@ const (
 	ContentTypePPT    = ".ppt"
 	ContentTypePPTX   = ".pptx"
 	ContentTypeRAR    = ".rar"
+	ContentTypeRSS    = ".rss"
 	ContentTypeRTF    = ".rtf"
 	ContentTypeSH     = ".sh"
 	ContentTypeSVG    = ".svg"
@@ -56,6 +61,7 @@ const (
 	ContentTypeTTF    = ".ttf"
 	ContentTypeTXT    = ".txt"
 	ContentTypeVSD    = ".vsd"
+	ContentTypeWASM   = ".wasm"
 	ContentTypeWAV    = ".wav"
 	ContentTypeWEBA   = ".weba"
 	ContentTypeWEBM   = ".webm"
@@ -68,81 +74,135 @@ const (
 	ContentTypeXML    = ".xml"
 	ContentTypeXUL    = ".xul"
 	ContentTypeZIP    = ".zip"
-	ContentType3GP    = ".3GP"
-	ContentType3G2    = ".3G2"
-	ContentType7Z     = ".7Z"
+	//不兼容变更: 以下三个原为大写的".3GP", ".3G2", ".7Z", 与注册表返回的小写后缀不一致, 已改为小写
+	ContentType3GP = ".3gp"
+	ContentType3G2 = ".3g2"
+	ContentType7Z  = ".7z"
+
+	//Deprecated: 拼写错误, 使用ContentTypeAAC
+	//不兼容变更: 原值为".acc", 现与ContentTypeAAC相同
+	ContentTypeACC = ContentTypeAAC
 )
 
-var ContentTypes = map[string]string{
-	"audio/aac":                    ContentTypeACC,
-	"application/x-abiwor":         ContentTypeABW,
-	"application/x-freearc":        ContentTypeARC,
-	"video/x-msvideo":              ContentTypeAVI,
-	"application/vnd.amazon.ebook": ContentTypeAZW,
-	"application/octet-stream":     ContentTypeBIN,
-	"image/bmp":                    ContentTypeBMP,
-	"application/x-bzip":           ContentTypeBZ,
-	"application/x-bzip2":          ContentTypeBZ2,
-	"application/x-csh":            ContentTypeCSH,
-	"text/css":                     ContentTypeCSS,
-	"text/csv":                     ContentTypeCSV,
-	"application/msword":           ContentTypeDOC,
-	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": ContentTypeDOCX,
+//Deprecated: 使用DefaultMimeRegistry.Extension
+//初始化时由DefaultMimeRegistry生成, 之后注册的类型不会出现在这里
+var ContentTypes = DefaultMimeRegistry.contentTypes()
 
-	"application/vnd.ms-fontobject":       ContentTypeEOT,
-	"application/epub+zip":                ContentTypeEPUB,
-	"image/gif":                           ContentTypeGIF,
-	"text/html":                           ContentTypeHTML,
-	"image/vnd.microsoft.ico":             ContentTypeICO,
-	"text/calendar":                       ContentTypeICS,
-	"application/java-archiv":             ContentTypeJAR,
-	"image/jpeg":                          ContentTypeJPEG,
-	"text/javascript":                     ContentTypeJS,
-	"application/javascript":              ContentTypeJS,
-	"application/json":                    ContentTypeJSON,
-	"application/ld+json":                 ContentTypeJSONLD,
-	"audio/midi audio/x-midi":             ContentTypeMIDI,
-	"audio/mpeg":                          ContentTypeMP3,
-	"video/mpeg":                          ContentTypeMPEG,
-	"application/vnd.apple.installer+xml": ContentTypeMPKG,
-	"application/vnd.oasis.opendocument.presentation": ContentTypeMPEG,
-	"application/vnd.oasis.opendocument.spreadsheet":  ContentTypeODP,
-	"application/vnd.oasis.opendocument.text":         ContentTypeODS,
-	"audio/ogg":                     ContentTypeODT,
-	"video/ogg":                     ContentTypeOGA,
-	"application/ogg":               ContentTypeOGX,
-	"font/otf":                      ContentTypeOTF,
-	"image/png":                     ContentTypePNG,
-	"application/pdf":               ContentTypePDF,
-	"application/vnd.ms-powerpoint": ContentTypePPT,
-	"application/vnd.openxmlformats-officedocument.presentationml.presentation": ContentTypePPTX,
+//默认类型表, 第一个后缀为该类型的规范后缀
+//https://www.iana.org/assignments/media-types/media-types.xhtml
+var defaultMimeTypes = []struct {
+	mediaType string
+	exts      []string
+}{
+	{"application/epub+zip", []string{ContentTypeEPUB}},
+	{"application/gzip", []string{ContentTypeGZ}},
+	{"application/java-archive", []string{ContentTypeJAR}},
+	{"application/json", []string{ContentTypeJSON}},
+	{"application/ld+json", []string{ContentTypeJSONLD}},
+	{"application/msword", []string{ContentTypeDOC}},
+	{"application/octet-stream", []string{ContentTypeBIN}},
+	{"application/ogg", []string{ContentTypeOGX}},
+	{"application/pdf", []string{ContentTypePDF}},
+	{"application/rtf", []string{ContentTypeRTF}},
+	{"application/atom+xml", []string{ContentTypeATOM}},
+	{"application/rss+xml", []string{ContentTypeRSS}},
+	{"application/vnd.amazon.ebook", []string{ContentTypeAZW}},
+	{"application/vnd.apple.installer+xml", []string{ContentTypeMPKG}},
+	{"application/vnd.ms-excel", []string{ContentTypeXLS}},
+	{"application/vnd.ms-fontobject", []string{ContentTypeEOT}},
+	{"application/vnd.ms-powerpoint", []string{ContentTypePPT}},
+	{"application/vnd.mozilla.xul+xml", []string{ContentTypeXUL}},
+	{"application/vnd.oasis.opendocument.presentation", []string{ContentTypeODP}},
+	{"application/vnd.oasis.opendocument.spreadsheet", []string{ContentTypeODS}},
+	{"application/vnd.oasis.opendocument.text", []string{ContentTypeODT}},
+	{"application/vnd.openxmlformats-officedocument.presentationml.presentation", []string{ContentTypePPTX}},
+	{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", []string{ContentTypeXLSX}},
+	{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", []string{ContentTypeDOCX}},
+	{"application/vnd.rar", []string{ContentTypeRAR}},
+	{"application/vnd.visio", []string{ContentTypeVSD}},
+	{"application/wasm", []string{ContentTypeWASM}},
+	{"application/x-7z-compressed", []string{ContentType7Z}},
+	{"application/x-abiword", []string{ContentTypeABW}},
+	{"application/x-bzip", []string{ContentTypeBZ}},
+	{"application/x-bzip2", []string{ContentTypeBZ2}},
+	{"application/x-csh", []string{ContentTypeCSH}},
+	{"application/x-freearc", []string{ContentTypeARC}},
+	{"application/x-sh", []string{ContentTypeSH}},
+	{"application/x-shockwave-flash", []string{ContentTypeSWF}},
+	{"application/x-tar", []string{ContentTypeTAR}},
+	{"application/xhtml+xml", []string{ContentTypeXHTML}},
+	{"application/xml", []string{ContentTypeXML}},
+	{"application/zip", []string{ContentTypeZIP}},
+	//.3gp和.3g2反向映射到video
+	{"video/3gpp", []string{ContentType3GP}},
+	{"video/3gpp2", []string{ContentType3G2}},
+	{"audio/3gpp", []string{ContentType3GP}},
+	{"audio/3gpp2", []string{ContentType3G2}},
+	{"audio/aac", []string{ContentTypeAAC}},
+	{"audio/midi", []string{ContentTypeMID, ContentTypeMIDI}},
+	{"audio/mpeg", []string{ContentTypeMP3}},
+	{"audio/ogg", []string{ContentTypeOGA}},
+	{"audio/wav", []string{ContentTypeWAV}},
+	{"audio/webm", []string{ContentTypeWEBA}},
+	{"font/otf", []string{ContentTypeOTF}},
+	{"font/ttf", []string{ContentTypeTTF}},
+	{"font/woff", []string{ContentTypeWOFF}},
+	{"font/woff2", []string{ContentTypeWOFF2}},
+	{"image/avif", []string{ContentTypeAVIF}},
+	{"image/bmp", []string{ContentTypeBMP}},
+	{"image/gif", []string{ContentTypeGIF}},
+	{"image/jpeg", []string{ContentTypeJPEG, ContentTypeJPG}},
+	{"image/png", []string{ContentTypePNG}},
+	{"image/svg+xml", []string{ContentTypeSVG}},
+	{"image/tiff", []string{ContentTypeTIFF, ContentTypeTIF}},
+	{"image/vnd.microsoft.icon", []string{ContentTypeICO}},
+	{"image/webp", []string{ContentTypeWEBP}},
+	{"text/calendar", []string{ContentTypeICS}},
+	{"text/css", []string{ContentTypeCSS}},
+	{"text/csv", []string{ContentTypeCSV}},
+	{"text/html", []string{ContentTypeHTML, ContentTypeHTM}},
+	{"text/javascript", []string{ContentTypeJS, ContentTypeMJS}},
+	{"text/plain", []string{ContentTypeTXT}},
+	{"video/mp4", []string{ContentTypeMP4}},
+	{"video/mpeg", []string{ContentTypeMPEG}},
+	{"video/ogg", []string{ContentTypeOGV}},
+	{"video/webm", []string{ContentTypeWEBM}},
+	{"video/x-msvideo", []string{ContentTypeAVI}},
+}
 
-	"application/x-rar-compressed":  ContentTypeRAR,
-	"application/rtf":               ContentTypeRTF,
-	"application/x-sh":              ContentTypeSH,
-	"image/svg+xml":                 ContentTypeSVG,
-	"application/x-shockwave-flash": ContentTypeSWF,
-	"application/x-tar":             ContentTypeTAR,
-	"image/tiff":                    ContentTypeTIFF,
-	"font/ttf":                      ContentTypeTTF,
-	"text/plain":                    ContentTypeTXT,
-	"application/vnd.visio":         ContentTypeVSD,
-	"audio/wav":                     ContentTypeWAV,
-	"audio/webm":                    ContentTypeWEBA,
-	"video/webm":                    ContentTypeWEBM,
-	"image/webp":                    ContentTypeWEBP,
-	"font/woff":                     ContentTypeWOFF,
-	"font/woff2":                    ContentTypeWOFF2,
-	"application/xhtml+xml":         ContentTypeXHTML,
-	"application/vnd.ms-excel":      ContentTypeXLS,
-	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": ContentTypeXLSX,
+//非标准或已废弃的类型名
+var defaultMimeAliases = map[string]string{
+	"application/ecmascript":       "text/javascript",
+	"application/javascript":       "text/javascript",
+	"application/x-javascript":     "text/javascript",
+	"application/x-gzip":           "application/gzip",
+	"application/x-rar-compressed": "application/vnd.rar",
+	"application/x-zip-compressed": "application/zip",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/vnd.wave":               "audio/wav",
+	"audio/wave":                   "audio/wav",
+	"audio/x-midi":                 "audio/midi",
+	"audio/x-wav":                  "audio/wav",
+	"image/jpg":                    "image/jpeg",
+	"image/x-icon":                 "image/vnd.microsoft.icon",
+	"text/ecmascript":              "text/javascript",
+	"text/rtf":                     "application/rtf",
+	"text/xml":                     "application/xml",
+	"video/avi":                    "video/x-msvideo",
+}
+
+//RFC 6839 structured syntax suffix
+var defaultMimeStructuredSuffixes = map[string]string{
+	"+json": "application/json",
+	"+xml":  "application/xml",
+	"+zip":  "application/zip",
+}
 
-	"application/xml":                 ContentTypeXML,
-	"application/vnd.mozilla.xul+xml": ContentTypeXUL,
-	"application/zip":                 ContentTypeZIP,
-	"video/3gpp":                      ContentType3GP,
-	"audio/3gpp":                      ContentType3GP,
-	"video/3gpp2":                     ContentType3G2,
-	"audio/3gpp2":                     ContentType3G2,
-	"application/x-7z-compressed":     ContentType7Z,
+var defaultMimeWildcards = map[string]string{
+	"application/*": ContentTypeBIN,
+	"audio/*":       ContentTypeBIN,
+	"font/*":        ContentTypeBIN,
+	"image/*":       ContentTypeBIN,
+	"text/*":        ContentTypeTXT,
+	"video/*":       ContentTypeBIN,
 }
@@ -0,0 +1,206 @@
+package spider
+
+import (
+	"strings"
+	"sync"
+)
+
+//媒体类型和后缀的双向映射
+//查找顺序: 精确类型 -> 别名 -> structured syntax suffix(+xml, +json) -> 通配(text/*)
+type MimeRegistry struct {
+	mutex sync.RWMutex
+
+	types      map[string]string //media type -> 规范后缀
+	aliases    map[string]string //alias -> media type
+	exts       map[string]string //后缀 -> media type
+	structured map[string]string //+xml -> media type
+	wildcards  map[string]string //text -> 后缀
+}
+
+var DefaultMimeRegistry = NewDefaultMimeRegistry()
+
+func NewMimeRegistry() *MimeRegistry {
+	return &MimeRegistry{
+		types:      make(map[string]string),
+		aliases:    make(map[string]string),
+		exts:       make(map[string]string),
+		structured: make(map[string]string),
+		wildcards:  make(map[string]string),
+	}
+}
+
+func NewDefaultMimeRegistry() *MimeRegistry {
+	mr := NewMimeRegistry()
+	for _, mt := range defaultMimeTypes {
+		mr.Register(mt.mediaType, mt.exts...)
+	}
+	for alias, mediaType := range defaultMimeAliases {
+		mr.RegisterAlias(alias, mediaType)
+	}
+	for suffix, mediaType := range defaultMimeStructuredSuffixes {
+		mr.RegisterStructuredSuffix(suffix, mediaType)
+	}
+	for pattern, ext := range defaultMimeWildcards {
+		mr.RegisterWildcard(pattern, ext)
+	}
+	return mr
+}
+
+//第一个后缀为规范后缀; 后缀已被其他类型注册时不覆盖反向映射
+func (mr *MimeRegistry) Register(mediaType string, exts ...string) {
+	if len(exts) == 0 {
+		return
+	}
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	mediaType = normalizeMediaType(mediaType)
+	mr.types[mediaType] = normalizeExt(exts[0])
+	for _, ext := range exts {
+		ext = normalizeExt(ext)
+		if _, ok := mr.exts[ext]; !ok {
+			mr.exts[ext] = mediaType
+		}
+	}
+}
+
+func (mr *MimeRegistry) RegisterAlias(alias, mediaType string) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	mr.aliases[normalizeMediaType(alias)] = normalizeMediaType(mediaType)
+}
+
+//比如+xml映射到application/xml, 则application/rss+xml未注册时按application/xml处理
+func (mr *MimeRegistry) RegisterStructuredSuffix(suffix, mediaType string) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	if !strings.HasPrefix(suffix, "+") {
+		suffix = "+" + suffix
+	}
+	mr.structured[strings.ToLower(suffix)] = normalizeMediaType(mediaType)
+}
+
+//pattern形如text/*
+func (mr *MimeRegistry) RegisterWildcard(pattern, ext string) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	top := strings.TrimSuffix(normalizeMediaType(pattern), "/*")
+	mr.wildcards[top] = normalizeExt(ext)
+}
+
+//媒体类型对应的后缀
+func (mr *MimeRegistry) Extension(mediaType string) (string, bool) {
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+
+	mediaType = normalizeMediaType(mediaType)
+	if ext, ok := mr.extension(mediaType); ok {
+		return ext, true
+	}
+
+	slash := strings.Index(mediaType, "/")
+	if slash <= 0 {
+		return "", false
+	}
+	if plus := strings.LastIndex(mediaType, "+"); plus > slash {
+		if base, ok := mr.structured[mediaType[plus:]]; ok {
+			if ext, ok := mr.extension(base); ok {
+				return ext, true
+			}
+		}
+	}
+	ext, ok := mr.wildcards[mediaType[:slash]]
+	return ext, ok
+}
+
+//后缀对应的媒体类型
+func (mr *MimeRegistry) MediaType(ext string) (string, bool) {
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+
+	mediaType, ok := mr.exts[normalizeExt(ext)]
+	return mediaType, ok
+}
+
+//别名对应的规范类型, 不是别名时原样返回
+func (mr *MimeRegistry) Canonical(mediaType string) string {
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+
+	mediaType = normalizeMediaType(mediaType)
+	if canonical, ok := mr.aliases[mediaType]; ok {
+		return canonical
+	}
+	return mediaType
+}
+
+//媒体类型和别名到后缀的快照, 不包含structured syntax suffix和通配
+func (mr *MimeRegistry) contentTypes() map[string]string {
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+
+	contentTypes := make(map[string]string, len(mr.types)+len(mr.aliases))
+	for mediaType, ext := range mr.types {
+		contentTypes[mediaType] = ext
+	}
+	for alias := range mr.aliases {
+		if ext, ok := mr.extension(alias); ok {
+			contentTypes[alias] = ext
+		}
+	}
+	return contentTypes
+}
+
+//不经过通配即可识别
+func (mr *MimeRegistry) registered(mediaType string) bool {
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+
+	mediaType = normalizeMediaType(mediaType)
+	if _, ok := mr.extension(mediaType); ok {
+		return true
+	}
+	return mr.structuredBaseLocked(mediaType) != ""
+}
+
+//application/rss+xml的structured syntax suffix对应的类型, 没有时返回空
+func (mr *MimeRegistry) structuredBase(mediaType string) string {
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+
+	return mr.structuredBaseLocked(normalizeMediaType(mediaType))
+}
+
+func (mr *MimeRegistry) structuredBaseLocked(mediaType string) string {
+	slash := strings.Index(mediaType, "/")
+	if plus := strings.LastIndex(mediaType, "+"); slash > 0 && plus > slash {
+		return mr.structured[mediaType[plus:]]
+	}
+	return ""
+}
+
+func (mr *MimeRegistry) extension(mediaType string) (string, bool) {
+	if canonical, ok := mr.aliases[mediaType]; ok {
+		mediaType = canonical
+	}
+	ext, ok := mr.types[mediaType]
+	return ext, ok
+}
+
+func normalizeMediaType(mediaType string) string {
+	if semicolon := strings.Index(mediaType, ";"); semicolon >= 0 {
+		mediaType = mediaType[:semicolon]
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
@@ -0,0 +1,186 @@
+package spider
+
+import (
+	"strings"
+	"testing"
+)
+
+//go test -v -run=Test_MimeRegistryDefault
+func Test_MimeRegistryDefault(t *testing.T) {
+	extensions := []struct {
+		mediaType string
+		ext       string
+	}{
+		{"image/jpeg", ".jpeg"},
+		{"Image/JPEG", ".jpeg"},
+		{"image/jpeg; quality=high", ".jpeg"},
+		{"image/jpg", ".jpeg"},
+		{" text/html ;charset=UTF-8", ".html"},
+		{"text/javascript", ".js"},
+		{"audio/3gpp", ".3gp"},
+		{"audio/midi", ".mid"},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", ".docx"},
+	}
+	for _, c := range extensions {
+		if got, ok := DefaultMimeRegistry.Extension(c.mediaType); !ok || got != c.ext {
+			t.Errorf("%q: expected %s, got %s", c.mediaType, c.ext, got)
+		}
+	}
+
+	mediaTypes := []struct {
+		ext       string
+		mediaType string
+	}{
+		{".jpeg", "image/jpeg"},
+		{".jpg", "image/jpeg"},
+		{".JPG", "image/jpeg"},
+		{"jpeg", "image/jpeg"},
+		{".htm", "text/html"},
+		{".mid", "audio/midi"},
+		{".midi", "audio/midi"},
+	}
+	for _, c := range mediaTypes {
+		if got, ok := DefaultMimeRegistry.MediaType(c.ext); !ok || got != c.mediaType {
+			t.Errorf("%q: expected %s, got %s", c.ext, c.mediaType, got)
+		}
+	}
+
+	//兼容旧的ContentTypes
+	if ContentTypes["image/jpeg"] != ContentTypeJPEG ||
+		ContentTypes["application/javascript"] != ContentTypeJS ||
+		ContentTypes["audio/aac"] != ContentTypeACC {
+		t.Errorf("deprecated ContentTypes should follow the default registry")
+	}
+}
+
+//go test -v -run=Test_MimeRegistryIANA
+func Test_MimeRegistryIANA(t *testing.T) {
+	//取自IANA注册模板中的File extension(s)字段
+	//https://www.iana.org/assignments/media-types/media-types.xhtml
+	iana := []struct {
+		mediaType string
+		exts      []string
+	}{
+		{"application/atom+xml", []string{".atom"}},
+		{"application/epub+zip", []string{".epub"}},
+		{"application/gzip", []string{".gz"}},
+		{"application/json", []string{".json"}},
+		{"application/ld+json", []string{".jsonld"}},
+		{"application/msword", []string{".doc"}},
+		{"application/ogg", []string{".ogx"}},
+		{"application/pdf", []string{".pdf"}},
+		{"application/rtf", []string{".rtf"}},
+		{"application/vnd.amazon.ebook", []string{".azw"}},
+		{"application/vnd.apple.installer+xml", []string{".mpkg"}},
+		{"application/vnd.ms-excel", []string{".xls"}},
+		{"application/vnd.ms-fontobject", []string{".eot"}},
+		{"application/vnd.ms-powerpoint", []string{".ppt"}},
+		{"application/vnd.mozilla.xul+xml", []string{".xul"}},
+		{"application/vnd.oasis.opendocument.presentation", []string{".odp"}},
+		{"application/vnd.oasis.opendocument.spreadsheet", []string{".ods"}},
+		{"application/vnd.oasis.opendocument.text", []string{".odt"}},
+		{"application/vnd.openxmlformats-officedocument.presentationml.presentation", []string{".pptx"}},
+		{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", []string{".xlsx"}},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", []string{".docx"}},
+		{"application/vnd.rar", []string{".rar"}},
+		{"application/vnd.visio", []string{".vsd"}},
+		{"application/wasm", []string{".wasm"}},
+		{"application/xhtml+xml", []string{".xhtml"}},
+		{"application/xml", []string{".xml"}},
+		{"application/zip", []string{".zip"}},
+		{"audio/3gpp", []string{".3gp"}},
+		{"audio/3gpp2", []string{".3g2"}},
+		{"audio/aac", []string{".aac"}},
+		{"audio/mpeg", []string{".mp3"}},
+		{"audio/ogg", []string{".oga"}},
+		{"font/otf", []string{".otf"}},
+		{"font/ttf", []string{".ttf"}},
+		{"font/woff", []string{".woff"}},
+		{"font/woff2", []string{".woff2"}},
+		{"image/avif", []string{".avif"}},
+		{"image/bmp", []string{".bmp"}},
+		{"image/gif", []string{".gif"}},
+		{"image/jpeg", []string{".jpeg", ".jpg"}},
+		{"image/png", []string{".png"}},
+		{"image/svg+xml", []string{".svg"}},
+		{"image/tiff", []string{".tiff", ".tif"}},
+		{"image/vnd.microsoft.icon", []string{".ico"}},
+		{"image/webp", []string{".webp"}},
+		{"text/calendar", []string{".ics"}},
+		{"text/css", []string{".css"}},
+		{"text/csv", []string{".csv"}},
+		{"text/html", []string{".html", ".htm"}},
+		{"text/javascript", []string{".js", ".mjs"}},
+		{"text/plain", []string{".txt"}},
+		{"video/3gpp", []string{".3gp"}},
+		{"video/3gpp2", []string{".3g2"}},
+		{"video/mp4", []string{".mp4"}},
+		{"video/mpeg", []string{".mpeg"}},
+		{"video/ogg", []string{".ogv"}},
+	}
+	for _, c := range iana {
+		if got, ok := DefaultMimeRegistry.Extension(c.mediaType); !ok || got != c.exts[0] {
+			t.Errorf("%s: expected %s, got %s", c.mediaType, c.exts[0], got)
+		}
+		//audio/3gpp与video/3gpp共用后缀, 反向映射到video
+		if strings.HasPrefix(c.mediaType, "audio/3gpp") {
+			continue
+		}
+		for _, ext := range c.exts {
+			if got, ok := DefaultMimeRegistry.MediaType(ext); !ok || got != c.mediaType {
+				t.Errorf("%s: expected %s, got %s", ext, c.mediaType, got)
+			}
+		}
+	}
+}
+
+//go test -v -run=Test_MimeRegistryLookup
+func Test_MimeRegistryLookup(t *testing.T) {
+	extensions := map[string]string{
+		"text/html; charset=utf-8":      ".html",
+		"TEXT/HTML":                     ".html",
+		"audio/x-midi":                  ".mid",
+		"application/javascript":        ".js",
+		"text/xml":                      ".xml",
+		"application/vnd.foo+json":      ".json",
+		"application/vnd.bar+xml":       ".xml",
+		"text/x-unknown":                ".txt",
+		"application/x-unknown":         ".bin",
+		"application/x-rar-compressed":  ".rar",
+		"image/x-icon":                  ".ico",
+		"application/problem+json; q=1": ".json",
+	}
+	for mediaType, ext := range extensions {
+		if got, ok := DefaultMimeRegistry.Extension(mediaType); !ok || got != ext {
+			t.Errorf("%s: expected %s, got %s", mediaType, ext, got)
+		}
+	}
+	if _, ok := DefaultMimeRegistry.Extension("model/x-unknown"); ok {
+		t.Errorf("model/x-unknown should not resolve")
+	}
+
+	mediaTypes := map[string]string{
+		".htm":  "text/html",
+		".jpg":  "image/jpeg",
+		"midi":  "audio/midi",
+		".3gp":  "video/3gpp",
+		".MJS":  "text/javascript",
+		".tif":  "image/tiff",
+		".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	}
+	for ext, mediaType := range mediaTypes {
+		if got, ok := DefaultMimeRegistry.MediaType(ext); !ok || got != mediaType {
+			t.Errorf("%s: expected %s, got %s", ext, mediaType, got)
+		}
+	}
+
+	registry := NewMimeRegistry()
+	registry.Register("application/x-custom", "cst")
+	registry.RegisterAlias("application/x-custom-old", "application/x-custom")
+	if ext, _ := registry.Extension("application/x-custom-old"); ext != ".cst" {
+		t.Errorf("alias should resolve to .cst, got %s", ext)
+	}
+	if _, ok := registry.Extension("text/html"); ok {
+		t.Errorf("empty registry should not resolve text/html")
+	}
+}
@@ -0,0 +1,403 @@
+package spider
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	mirrorSegmentMax = 200
+	mirrorQueryMax   = 100
+)
+
+var ErrMirrorPathEscape = errors.New("mirror path escapes root")
+
+//按host和path保存, 目录保存为index.html, 抓取结束后调用ConvertLinks把链接改写为本地的相对路径
+//不保存header和url文件, Download返回的url和header路径为nil
+type MirrorDownloader struct {
+	root string
+
+	files map[string]string //url到相对root的路径
+	paths map[string]string //路径到url, 用于发现冲突
+	dirs  map[string]bool   //已经作为目录使用的路径
+	mutex sync.Mutex
+}
+
+func NewMirrorDownloader(root string) *MirrorDownloader {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return &MirrorDownloader{
+		root:  root,
+		files: make(map[string]string),
+		paths: make(map[string]string),
+		dirs:  make(map[string]bool),
+	}
+}
+
+func (md *MirrorDownloader) Download(u *url.URL, header http.Header, reader io.Reader, suffix string) (*string, *string, *string, error) {
+	key := mirrorKey(u)
+	md.mutex.Lock()
+	rel, ok := md.files[key]
+	if !ok {
+		rel = md.resolve(key, MirrorPath(u, suffix))
+		md.files[key] = rel
+		md.paths[rel] = key
+	}
+	md.mutex.Unlock()
+
+	bodyPath, err := md.local(rel)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(bodyPath), 0755)
+	}
+	if err == nil {
+		err = writeAtomic(reader, bodyPath)
+	}
+	if err != nil {
+		md.forget(rel)
+		return nil, nil, nil, err
+	}
+	return nil, nil, &bodyPath, nil
+}
+
+//同一个url的请求可能并发下载(比如只有fragment不同), 先写临时文件再改名, 不会留下交错或不完整的文件
+func writeAtomic(reader io.Reader, path string) error {
+	fd, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.part")
+	if err != nil {
+		return err
+	}
+	tmp := fd.Name()
+	_, err = io.Copy(fd, reader)
+	if errC := fd.Close(); err == nil {
+		err = errC
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (md *MirrorDownloader) Remove(urlPath, headerPath, bodyPath *string) error {
+	if bodyPath == nil {
+		return nil
+	}
+	if err := os.Remove(*bodyPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	rel, err := filepath.Rel(md.root, *bodyPath)
+	if err != nil {
+		return err
+	}
+	md.forget(filepath.ToSlash(rel))
+	return nil
+}
+
+func (md *MirrorDownloader) forget(rel string) {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+	delete(md.files, md.paths[rel])
+	delete(md.paths, rel)
+}
+
+//不同的url映射到同一个路径时, 后来的加上url的hash
+//比如/x.html和/x.html/y, 同一个路径既要作为文件又要作为目录时:
+//目录和已有的文件冲突时目录加上目录路径的hash, 同一目录下的文件仍然在一起; 文件和已有的目录冲突时文件加上url的hash
+func (md *MirrorDownloader) resolve(key, rel string) string {
+	segments := strings.Split(rel, "/")
+	for i := 1; i < len(segments); i++ {
+		dir := strings.Join(segments[:i], "/")
+		if _, file := md.paths[dir]; file {
+			segments[i-1] += "-" + shortHash(dir)
+		}
+	}
+	rel = strings.Join(segments, "/")
+
+	if other, exists := md.paths[rel]; (exists && other != key) || md.dirs[rel] {
+		ext := path.Ext(rel)
+		rel = strings.TrimSuffix(rel, ext) + "-" + shortHash(key) + ext
+	}
+	for dir := path.Dir(rel); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		md.dirs[dir] = true
+	}
+	return rel
+}
+
+//相对root的路径转为绝对路径, 不允许离开root
+func (md *MirrorDownloader) local(rel string) (string, error) {
+	local := filepath.Join(md.root, filepath.FromSlash(rel))
+	inner, err := filepath.Rel(md.root, local)
+	if err != nil || inner == "." || inner == ".." || strings.HasPrefix(inner, ".."+string(filepath.Separator)) {
+		return "", ErrMirrorPathEscape
+	}
+	return local, nil
+}
+
+//host/path形式的相对路径, 使用/分隔
+//..和.在path.Clean时消除, 文件名中不安全的字符替换为_
+//query以@连接在文件名之后, 文件名的后缀和suffix不是同一种类型时补上suffix, 方便离线浏览时按类型打开
+func MirrorPath(u *url.URL, suffix string) string {
+	p := u.Path
+	dir := p == "" || strings.HasSuffix(p, "/")
+	p = path.Clean("/" + p)
+
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		if segment != "" {
+			segments = append(segments, mirrorSegment(segment))
+		}
+	}
+	name := "index"
+	if !dir && len(segments) > 0 {
+		name = segments[len(segments)-1]
+		segments = segments[:len(segments)-1]
+	}
+	if u.RawQuery != "" {
+		query := mirrorSegment(u.RawQuery)
+		if len(query) > mirrorQueryMax {
+			query = shortHash(u.RawQuery)
+		}
+		name += "@" + query
+	}
+	if suffix == "" && name == "index" {
+		suffix = ContentTypeHTML
+	}
+	if suffix != "" && !sameMediaType(path.Ext(name), suffix) {
+		name += suffix
+	}
+
+	host := mirrorSegment(strings.ToLower(u.Host))
+	if host == "" {
+		host = "_"
+	}
+	return path.Join(append(append([]string{host}, segments...), name)...)
+}
+
+//.jpg和.jpeg、.htm和.html等对应同一种类型
+func sameMediaType(ext, suffix string) bool {
+	if strings.EqualFold(ext, suffix) {
+		return true
+	}
+	extType, ok := DefaultMimeRegistry.MediaType(ext)
+	if !ok {
+		return false
+	}
+	suffixType, ok := DefaultMimeRegistry.MediaType(suffix)
+	return ok && extType == suffixType
+}
+
+func mirrorSegment(segment string) string {
+	segment = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, segment)
+	if segment == "." || segment == ".." {
+		return "_"
+	}
+	if len(segment) > mirrorSegmentMax {
+		segment = segment[:mirrorSegmentMax] + "-" + shortHash(segment)
+	}
+	return segment
+}
+
+func shortHash(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:4])
+}
+
+//忽略fragment
+func mirrorKey(u *url.URL) string {
+	copied := *u
+	copied.Fragment = ""
+	copied.RawFragment = ""
+	return copied.String()
+}
+
+//把html和css中指向已镜像url的链接改写为相对路径, 其他http(s)链接改写为绝对url
+func (md *MirrorDownloader) ConvertLinks() error {
+	md.mutex.Lock()
+	files := make(map[string]string, len(md.files))
+	for key, rel := range md.files {
+		files[key] = rel
+	}
+	md.mutex.Unlock()
+
+	for key, rel := range files {
+		ext := strings.ToLower(path.Ext(rel))
+		if ext != ContentTypeHTML && ext != ContentTypeHTM && ext != ContentTypeXHTML && ext != ContentTypeCSS {
+			continue
+		}
+		base, err := url.Parse(key)
+		if err != nil {
+			continue
+		}
+		local, err := md.local(rel)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(local)
+		if err != nil {
+			return err
+		}
+
+		converter := &linkConverter{files: files, from: rel}
+		if ext == ContentTypeCSS {
+			data = converter.css(base, data)
+		} else {
+			data = converter.html(base, data)
+		}
+		if err = ioutil.WriteFile(local, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type linkConverter struct {
+	files map[string]string
+	from  string
+}
+
+func (lc *linkConverter) convert(base *url.URL, link string) string {
+	trimmed := strings.TrimSpace(link)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return link
+	}
+	u, err := base.Parse(trimmed)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return link
+	}
+	rel, ok := lc.files[mirrorKey(u)]
+	if !ok {
+		return u.String()
+	}
+
+	target := relPath(path.Dir(lc.from), rel)
+	converted := (&url.URL{Path: target}).String()
+	if u.Fragment != "" {
+		converted += "#" + u.EscapedFragment()
+	}
+	return converted
+}
+
+func relPath(fromDir, to string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(fromDir), filepath.FromSlash(to))
+	if err != nil {
+		return to
+	}
+	return filepath.ToSlash(rel)
+}
+
+var (
+	cssUrlPattern    = regexp.MustCompile(`(?i)(url\(\s*)(['"]?)([^'")]*)(['"]?)(\s*\))`)
+	cssImportPattern = regexp.MustCompile(`(?i)(@import\s+)(['"])([^'"]*)(['"])`)
+)
+
+func (lc *linkConverter) css(base *url.URL, data []byte) []byte {
+	data = cssUrlPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := cssUrlPattern.FindSubmatch(match)
+		link := lc.convert(base, string(groups[3]))
+		return bytes.Join([][]byte{groups[1], groups[2], []byte(link), groups[4], groups[5]}, nil)
+	})
+	return cssImportPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := cssImportPattern.FindSubmatch(match)
+		link := lc.convert(base, string(groups[3]))
+		return bytes.Join([][]byte{groups[1], groups[2], []byte(link), groups[4]}, nil)
+	})
+}
+
+//包含链接的属性
+var mirrorLinkAttrs = map[string]bool{
+	"href": true, "src": true, "poster": true, "background": true, "data": true, "lowsrc": true,
+}
+
+//只重写包含链接的标签, 其他内容保持原样
+//<base>被删除, 否则相对路径会相对于线上地址解析
+func (lc *linkConverter) html(base *url.URL, data []byte) []byte {
+	var buffer bytes.Buffer
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	inStyle := false
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		//Token会原地转换标签名的大小写, 先复制原始内容
+		raw := append([]byte(nil), tokenizer.Raw()...)
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data == "base" {
+				for _, attr := range token.Attr {
+					if attr.Key == "href" {
+						if baseU, err := base.Parse(strings.TrimSpace(attr.Val)); err == nil {
+							base = baseU
+						}
+					}
+				}
+				continue
+			}
+			inStyle = token.Data == "style" && tt == html.StartTagToken
+			changed := false
+			for i, attr := range token.Attr {
+				value := attr.Val
+				switch {
+				case mirrorLinkAttrs[attr.Key]:
+					value = lc.convert(base, attr.Val)
+				case attr.Key == "srcset":
+					//按解析出的候选重新拼接, 避免一个url是另一个的前缀时替换错位置
+					var candidates []string
+					converted := false
+					for _, candidate := range parseSrcset(attr.Val) {
+						link := lc.convert(base, candidate.url)
+						converted = converted || link != candidate.url
+						if candidate.descriptor != "" {
+							link += " " + candidate.descriptor
+						}
+						candidates = append(candidates, link)
+					}
+					if converted {
+						value = strings.Join(candidates, ", ")
+					}
+				case attr.Key == "style":
+					value = string(lc.css(base, []byte(attr.Val)))
+				}
+				if value != attr.Val {
+					token.Attr[i].Val = value
+					changed = true
+				}
+			}
+			if changed {
+				buffer.WriteString(token.String())
+				continue
+			}
+		case html.TextToken:
+			if inStyle {
+				buffer.Write(lc.css(base, raw))
+				continue
+			}
+		case html.EndTagToken:
+			inStyle = false
+		}
+		buffer.Write(raw)
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,208 @@
+package spider
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//go test -v -run=Test_MirrorPath
+func Test_MirrorPath(t *testing.T) {
+	cases := []struct {
+		url    string
+		suffix string
+		path   string
+	}{
+		{"http://Example.com", ContentTypeHTML, "example.com/index.html"},
+		{"http://example.com/a/b/", ContentTypeHTML, "example.com/a/b/index.html"},
+		{"http://example.com/news/1", ContentTypeHTML, "example.com/news/1.html"},
+		{"http://example.com/img/logo.png", ContentTypePNG, "example.com/img/logo.png"},
+		{"http://example.com/photo.jpg", ContentTypeJPEG, "example.com/photo.jpg"},
+		{"http://example.com/old.htm", ContentTypeHTML, "example.com/old.htm"},
+		{"http://example.com/data.php", ContentTypeHTML, "example.com/data.php.html"},
+		{"http://example.com/list?page=2&q=a/b", ContentTypeHTML, "example.com/list@page=2&q=a_b.html"},
+		{"http://127.0.0.1:8080/", ContentTypeHTML, "127.0.0.1_8080/index.html"},
+		{"http://example.com/../../etc/passwd", "", "example.com/etc/passwd"},
+		{"http://example.com/%2e%2e/%2e%2e/etc/passwd", "", "example.com/etc/passwd"},
+		{`http://example.com/..\..\etc`, "", "example.com/.._.._etc"},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if p := MirrorPath(u, c.suffix); p != c.path {
+			t.Errorf("%s: expected %s, got %s", c.url, c.path, p)
+		}
+	}
+
+	md := NewMirrorDownloader(t.TempDir())
+	if _, err := md.local("../escape"); err != ErrMirrorPathEscape {
+		t.Errorf("paths outside root should be rejected")
+	}
+	md = NewMirrorDownloader("/")
+	if local, err := md.local("example.com/a.html"); err != nil || local != filepath.FromSlash("/example.com/a.html") {
+		t.Errorf("root of / should be usable, got %s, %v", local, err)
+	}
+}
+
+//go test -v -run=Test_MirrorDownloaderConflict
+func Test_MirrorDownloaderConflict(t *testing.T) {
+	md := NewMirrorDownloader(t.TempDir())
+	download := func(rawurl string) string {
+		u, _ := url.Parse(rawurl)
+		_, _, bodyPath, err := md.Download(u, nil, strings.NewReader(rawurl), ContentTypeHTML)
+		if err != nil {
+			t.Errorf("%s: %v", rawurl, err)
+			return ""
+		}
+		data, err := ioutil.ReadFile(*bodyPath)
+		if err != nil || string(data) != rawurl {
+			t.Errorf("%s: unexpected content %s, %v", rawurl, data, err)
+		}
+		return *bodyPath
+	}
+
+	//先文件后目录, 目录改名, 同一目录下的文件仍然在一起
+	file := download("http://example.com/x.html")
+	child := download("http://example.com/x.html/y")
+	sibling := download("http://example.com/x.html/z")
+	if file == child || filepath.Dir(child) != filepath.Dir(sibling) {
+		t.Errorf("unexpected paths %s, %s, %s", file, child, sibling)
+	}
+	//先目录后文件, 文件改名
+	child = download("http://example.com/a/b")
+	file = download("http://example.com/a")
+	if filepath.Dir(child) == file {
+		t.Errorf("file should not take the path of a directory: %s", file)
+	}
+	//内容都没有被覆盖
+	for _, rawurl := range []string{"http://example.com/x.html", "http://example.com/x.html/y", "http://example.com/a/b"} {
+		u, _ := url.Parse(rawurl)
+		local, _ := md.local(md.files[mirrorKey(u)])
+		if data, err := ioutil.ReadFile(local); err != nil || string(data) != rawurl {
+			t.Errorf("%s was overwritten: %s, %v", rawurl, data, err)
+		}
+	}
+}
+
+//分块读取, 让并发的写入交错
+type slowReader struct {
+	reader io.Reader
+}
+
+func (sr *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	if len(p) > 64<<10 {
+		p = p[:64<<10]
+	}
+	return sr.reader.Read(p)
+}
+
+//go test -v -run=Test_MirrorDownloaderConcurrent
+func Test_MirrorDownloaderConcurrent(t *testing.T) {
+	md := NewMirrorDownloader(t.TempDir())
+	size := 1 << 20
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			//只有fragment不同的url保存到同一个文件
+			u, _ := url.Parse(fmt.Sprintf("http://example.com/big#%d", i))
+			body := &slowReader{reader: strings.NewReader(strings.Repeat(string(rune('a'+i)), size))}
+			if _, _, _, err := md.Download(u, nil, body, ContentTypeTXT); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	u, _ := url.Parse("http://example.com/big")
+	local, _ := md.local(md.files[mirrorKey(u)])
+	data, err := ioutil.ReadFile(local)
+	if err != nil || len(data) != size || strings.Trim(string(data), string(data[:1])) != "" {
+		t.Errorf("concurrent downloads should leave one complete body, got %d bytes, %v", len(data), err)
+	}
+	if entries, _ := ioutil.ReadDir(filepath.Dir(local)); len(entries) != 1 {
+		t.Errorf("temporary files should be removed, got %d entries", len(entries))
+	}
+}
+
+//go test -v -run=Test_MirrorDownloader
+func Test_MirrorDownloader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><LINK rel="stylesheet" href="/style.css"></head><body>
+<a href="/news/1#top">news</a> <a href="/list?page=2">list</a> <a href="http://other.com/x">other</a>
+<img srcset="pic?v=2 2x, pic 1x">
+</body></html>`)
+		case "/news/1", "/list":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/">home</a><div style="background: url('/img/bg.png')"></div>`)
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `body { background: url(/img/bg.png) }`)
+		case "/img/bg.png", "/pic":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG\r\n\x1a\n"))
+		}
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	md := NewMirrorDownloader(root)
+	spider := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderDownloader(md),
+	)
+	seed, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	spider.AddRequest(seed).Run()
+	if err := md.ConvertLinks(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	host := strings.Replace(strings.TrimPrefix(server.URL, "http://"), ":", "_", 1)
+	read := func(rel string) string {
+		data, err := ioutil.ReadFile(filepath.Join(root, host, rel))
+		if err != nil {
+			t.Error(err)
+		}
+		return string(data)
+	}
+	index := read("index.html")
+	for _, expected := range []string{
+		`<link rel="stylesheet" href="style.css">`,
+		`<a href="news/1.html#top">`,
+		`<a href="list@page=2.html">`,
+		`<a href="http://other.com/x">`,
+		//一个url是另一个的前缀时各自转换
+		`<img srcset="pic@v=2.png 2x, pic.png 1x">`,
+	} {
+		if !strings.Contains(index, expected) {
+			t.Errorf("index.html should contain %s, got %s", expected, index)
+		}
+	}
+	if news := read("news/1.html"); !strings.Contains(news, `href="../index.html"`) || !strings.Contains(news, `url(&#39;../img/bg.png&#39;)`) {
+		t.Errorf("unexpected news page %s", news)
+	}
+	if css := read("style.css"); css != `body { background: url(img/bg.png) }` {
+		t.Errorf("unexpected style sheet %s", css)
+	}
+	if png := read("img/bg.png"); png != "\x89PNG\r\n\x1a\n" {
+		t.Errorf("binary files should be kept as is")
+	}
+}
@@ -1,42 +1,139 @@
 package spider
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/xpath"
 	"github.com/cihub/seelog"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
 )
 
+//响应对应的CrawlRequest通过CrawlRequestOf取得, 子请求通过其Child创建
 type Processer interface {
-	Process(string, bool, *http.Response) ([]*http.Request, error)
+	Process(string, bool, *http.Response) ([]*CrawlRequest, error)
 	Finish()
 }
 
+//可选接口, Spider优先调用ProcessItems, 产出的Item附加到Result并进入ItemPipeline
+type ItemProcesser interface {
+	Processer
+	ProcessItems(string, bool, *http.Response) ([]*CrawlRequest, []Item, error)
+}
+
 const (
+	//旧版本的默认选择器, 现在默认使用DefaultLinkRules
 	SelectorDefault = "script, link, a, img, frame, iframe, area, base, blockquote, body, del, head, ins, object, q"
+
+	//<meta charset>可能不在前1024字节
+	charsetPrescanSize = 4096
 )
 
+//页面带有noindex时Process返回, 同时返回的请求仍然有效, 由Spider删除已下载的内容
+var ErrNoindex = errors.New("noindex")
+
 type OptionDomProcesser func(*DomProcesser)
 
+//选择器使用href, src, action等固定属性, 需要指定属性时使用OptionDomProcesserRules
 func OptionDomProcesserSelectors(selectors []string) OptionDomProcesser {
 	return func(processer *DomProcesser) {
-		processer.selectors = strings.Join(selectors, ",")
+		processer.rules = []LinkRule{{
+			Selector: strings.Join(selectors, ","),
+			Attrs:    legacyLinkAttrs,
+		}}
+	}
+}
+
+//替换DefaultLinkRules, 扩展时可以append到DefaultLinkRules之后传入
+func OptionDomProcesserRules(rules ...LinkRule) OptionDomProcesser {
+	return func(processer *DomProcesser) {
+		processer.rules = rules
+	}
+}
+
+//是否用<base href>解析相对链接
+func OptionDomProcesserBaseHref(honour bool) OptionDomProcesser {
+	return func(processer *DomProcesser) {
+		processer.baseHref = honour
+	}
+}
+
+//是否遵守rel="nofollow"以及meta robots和X-Robots-Tag中的nofollow
+func OptionDomProcesserNofollow(honour bool) OptionDomProcesser {
+	return func(processer *DomProcesser) {
+		processer.nofollow = honour
+	}
+}
+
+//是否遵守meta robots和X-Robots-Tag中的noindex, follow表示noindex页面是否继续发现链接
+func OptionDomProcesserNoindex(honour, follow bool) OptionDomProcesser {
+	return func(processer *DomProcesser) {
+		processer.noindex = honour
+		processer.noindexFollow = follow
+	}
+}
+
+//是否解析<style>和style属性中的url(...)和@import
+func OptionDomProcesserStyles(enabled bool) OptionDomProcesser {
+	return func(processer *DomProcesser) {
+		processer.styles = enabled
+	}
+}
+
+//是否从内联<script>中发现链接, 发现的请求为PriorityLow
+func OptionDomProcesserScripts(enabled bool) OptionDomProcesser {
+	return func(processer *DomProcesser) {
+		processer.scripts = enabled
+	}
+}
+
+//是否提交页面中的表单, provider为nil时只提交缺省值的GET表单, 缺省不提交
+func OptionDomProcesserForms(enabled bool, provider FormValueProvider) OptionDomProcesser {
+	return func(processer *DomProcesser) {
+		processer.forms = enabled
+		processer.formProvider = provider
 	}
 }
 
 type DomProcesser struct {
-	selectors string
+	rules   []LinkRule
+	styles  bool
+	scripts bool
+
+	forms        bool
+	formProvider FormValueProvider
+
+	baseHref      bool
+	nofollow      bool
+	noindex       bool
+	noindexFollow bool
 }
 
 func NewDomProcesser(options ...OptionDomProcesser) *DomProcesser {
-	dp := &DomProcesser{}
+	dp := &DomProcesser{
+		styles:        true,
+		scripts:       true,
+		baseHref:      true,
+		nofollow:      true,
+		noindex:       true,
+		noindexFollow: true,
+	}
 	for _, option := range options {
 		option(dp)
 	}
-	if dp.selectors == "" {
-		dp.selectors = SelectorDefault
+	if dp.rules == nil {
+		dp.rules = DefaultLinkRules
 	}
 	return dp
 }
@@ -45,111 +142,385 @@ func (dp *DomProcesser) Finish() {
 	return
 }
 
-func (dp *DomProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*http.Request, error) {
+func (dp *DomProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, error) {
+	reqs, _, err := dp.ProcessLinks(charSet, certain, rsp)
+	return reqs, err
+}
+
+//nofollow的页面同样记录链接, 但不跟进
+func (dp *DomProcesser) ProcessLinks(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, []*Link, error) {
 	defer rsp.Body.Close()
 
-	/*
-		utfReader, err := iconv.NewReader(rsp.Body, charSet, "utf-8")
-		if err != nil {
-			seelog.Errorf("DomProcesser::Process | ioconv new reader charset: %s, err: %s", charSet, err)
-			return nil, err
-		}
-		dom, err := goquery.NewDocumentFromReader(utfReader)
-		if err != nil {
-			seelog.Errorf("DomProcesser::Process | new document from reader err: %s", err)
-			return nil, err
-		}
-	*/
-	dom, err := goquery.NewDocumentFromResponse(rsp)
+	utfReader, err := utf8Reader(rsp, charSet, certain)
+	if err != nil {
+		seelog.Errorf("DomProcesser::ProcessLinks | utf8 reader charset: %s, err: %s", charSet, err)
+		return nil, nil, err
+	}
+	body, err := ioutil.ReadAll(utfReader)
+	if err != nil {
+		seelog.Errorf("DomProcesser::ProcessLinks | read all err: %s", err)
+		return nil, nil, err
+	}
+	dom, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		seelog.Errorf("DomProcesser::Process | new document from reader err: %s", err)
-		return nil, err
+		seelog.Errorf("DomProcesser::ProcessLinks | new document from reader err: %s", err)
+		return nil, nil, err
 	}
+	dom.Url = rsp.Request.URL
 
 	base := rsp.Request.URL.String()
+	if dp.baseHref {
+		if href, ok := dom.Find("base[href]").First().Attr("href"); ok {
+			if baseU, err := rsp.Request.URL.Parse(strings.TrimSpace(href)); err == nil {
+				base = baseU.String()
+			}
+		}
+	}
 
-	links := extractLinks(base, dom)
+	noindex, nofollow := robotsDirectives(rsp.Header, dom)
+	noindex = noindex && dp.noindex
+	nofollow = nofollow && dp.nofollow
+	follow := !nofollow && (!noindex || dp.noindexFollow)
 
-	var reqs []*http.Request
-	for _, link := range links {
-		req, err := http.NewRequest(http.MethodGet, link, nil)
-		if err != nil {
-			continue
+	refer := rsp.Request.URL.String()
+	links := newLinkCollector(refer, base)
+	styleLinks := newLinkCollector(refer, base)
+	scriptLinks := newLinkCollector(refer, base)
+	var root xpath.NodeNavigator
+	for _, rule := range dp.rules {
+		if rule.XPath != "" {
+			root = xpathRoot(rsp, body, dom)
+			break
+		}
+	}
+	extractLinks(links, dom, root, dp.rules, dp.nofollow)
+	if dp.styles {
+		extractStyleLinks(styleLinks, dom)
+	}
+	if dp.scripts {
+		extractScriptLinks(scriptLinks, dom)
+	}
+	records := append(append(links.links, styleLinks.links...), scriptLinks.links...)
+	if !follow {
+		for _, record := range records {
+			if record.Followed {
+				record.filter(LinkReasonRobots)
+			}
+		}
+		if noindex {
+			return nil, records, ErrNoindex
+		}
+		return nil, records, nil
+	}
+
+	parent := CrawlRequestOf(rsp)
+	reqs := linkRequests(parent, refer, links.followed(), SourceLink)
+	reqs = append(reqs, linkRequests(parent, refer, styleLinks.followed(), SourceStyle)...)
+	if dp.forms {
+		for _, req := range formRequests(rsp.Request.URL, ParseForms(base, dom), dp.formProvider) {
+			child := parent.Child(req, SourceForm)
+			child.Link = &Link{Source: refer, Target: req.URL.String(), Tag: "form", Attr: "action", Followed: true}
+			records = append(records, child.Link)
+			reqs = append(reqs, child)
 		}
-		//防防盗链
-		req.Header.Add("Refer", rsp.Request.URL.String())
+	}
+	for _, req := range linkRequests(parent, refer, scriptLinks.followed(), SourceScript) {
+		req.Priority = PriorityLow
 		reqs = append(reqs, req)
 	}
-	return reqs, nil
+	if noindex {
+		return reqs, records, ErrNoindex
+	}
+	return reqs, records, nil
 }
 
-func extractLinks(base string, doc *goquery.Document) []string {
-	internalUrls := []string{}
-	if doc != nil {
-		doc.Find(SelectorDefault).Each(func(i int, s *goquery.Selection) {
-			sub, exists := s.Attr("href")
-			if !exists {
-
-				sub, exists = s.Attr("src")
-				if !exists {
-
-					sub, exists = s.Attr("action")
-					if !exists {
-
-						sub, exists = s.Attr("codebase")
-						if !exists {
+//X-Robots-Tag和<meta name="robots">
+func robotsDirectives(header http.Header, doc *goquery.Document) (noindex bool, nofollow bool) {
+	directives := header.Values("X-Robots-Tag")
+	doc.Find("meta[name]").Each(func(i int, s *goquery.Selection) {
+		name, _ := s.Attr("name")
+		if strings.ToLower(strings.TrimSpace(name)) != "robots" {
+			return
+		}
+		if content, ok := s.Attr("content"); ok {
+			directives = append(directives, content)
+		}
+	})
 
-							sub, exists = s.Attr("cite")
-							if !exists {
+	for _, directive := range directives {
+		for _, value := range strings.Split(directive, ",") {
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "noindex":
+				noindex = true
+			case "nofollow":
+				nofollow = true
+			case "none":
+				noindex, nofollow = true, true
+			}
+		}
+	}
+	return
+}
 
-								sub, exists = s.Attr("longdesc")
-								if !exists {
+func containsNofollow(rel string) bool {
+	for _, value := range strings.Fields(strings.ToLower(rel)) {
+		if value == "nofollow" {
+			return true
+		}
+	}
+	return false
+}
 
-									sub, exists = s.Attr("usemap")
-									if !exists {
+//root为nil时忽略XPath规则
+func extractLinks(lc *linkCollector, doc *goquery.Document, root xpath.NodeNavigator, rules []LinkRule, nofollow bool) {
+	if doc == nil {
+		return
+	}
+	add := func(value string, parse func(string) []string, link Link) {
+		subs := []string{value}
+		if parse != nil {
+			subs = parse(value)
+		}
+		for _, sub := range subs {
+			record := link
+			lc.add(sub, &record)
+		}
+	}
 
-										sub, exists = s.Attr("profile")
-										if !exists {
-											return
-										}
-									}
-								}
-							}
-						}
-					}
+	exprs := xpathExprs{}
+	for _, rule := range rules {
+		if rule.XPath != "" {
+			xpathLinks(root, exprs, rule, nofollow, add)
+			continue
+		}
+		doc.Find(rule.Selector).Each(func(i int, s *goquery.Selection) {
+			rel, _ := s.Attr("rel")
+			link := Link{
+				Text:  collapseSpace(s.Text()),
+				Title: strings.TrimSpace(s.AttrOr("title", "")),
+				Rel:   relValues(rel),
+				Tag:   goquery.NodeName(s),
+			}
+			if nofollow && containsNofollow(rel) {
+				link.Reason = LinkReasonNofollow
+			}
+			for _, attr := range rule.Attrs {
+				if value, exists := s.Attr(attr); exists {
+					link.Attr = attr
+					add(value, rule.Parse, link)
 				}
 			}
+		})
+	}
+}
 
-			u := mergeUrl(base, sub)
-			if u != "" {
-				internalUrls = append(internalUrls, u)
+func xpathLinks(root xpath.NodeNavigator, exprs xpathExprs, rule LinkRule, nofollow bool, add func(string, func(string) []string, Link)) {
+	if root == nil {
+		return
+	}
+	expr, err := exprs.compile(rule.XPath)
+	if err != nil {
+		seelog.Errorf("Spider::xpathLinks | compile %s err: %s", rule.XPath, err)
+		return
+	}
+	iter, ok := expr.Evaluate(root.Copy()).(*xpath.NodeIterator)
+	if !ok {
+		for _, value := range xpathValues(root, expr) {
+			add(value, rule.Parse, Link{})
+		}
+		return
+	}
+
+	for iter.MoveNext() {
+		nav := iter.Current()
+		switch {
+		case nav.NodeType() == xpath.AttributeNode:
+			link := Link{Attr: nav.LocalName()}
+			value := nav.Value()
+			if element := nav.Copy(); element.MoveToParent() {
+				link.Tag = element.LocalName()
 			}
-			seelog.Infof("Spider::extractLinks | base: %s, sub: %s, after merge: %s", base, sub, u)
-		})
-		return internalUrls
+			add(value, rule.Parse, link)
+		case nav.NodeType() != xpath.ElementNode:
+			add(nav.Value(), rule.Parse, Link{})
+		default:
+			rel, _ := xpathAttr(nav, "rel")
+			title, _ := xpathAttr(nav, "title")
+			link := Link{
+				Text:  collapseSpace(nav.Value()),
+				Title: strings.TrimSpace(title),
+				Rel:   relValues(rel),
+				Tag:   nav.LocalName(),
+			}
+			if len(rule.Attrs) == 0 {
+				add(nav.Value(), rule.Parse, link)
+				continue
+			}
+			if nofollow && containsNofollow(rel) {
+				link.Reason = LinkReasonNofollow
+			}
+			for _, attr := range rule.Attrs {
+				if value, ok := xpathAttr(nav, attr); ok {
+					link.Attr = attr
+					add(value, rule.Parse, link)
+				}
+			}
+		}
 	}
-	return internalUrls
 }
 
+func extractStyleLinks(lc *linkCollector, doc *goquery.Document) {
+	doc.Find("style").Each(func(i int, s *goquery.Selection) {
+		for _, sub := range ExtractCssLinks(s.Text()) {
+			lc.add(sub, &Link{Tag: "style"})
+		}
+	})
+	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+		style, _ := s.Attr("style")
+		for _, sub := range ExtractCssLinks(style) {
+			lc.add(sub, &Link{Tag: goquery.NodeName(s), Attr: "style"})
+		}
+	})
+}
+
+//仅处理内联的javascript, 忽略src引用和json, 模板等其他type
+func extractScriptLinks(lc *linkCollector, doc *goquery.Document) {
+	doc.Find("script:not([src])").Each(func(i int, s *goquery.Selection) {
+		tp, _ := s.Attr("type")
+		tp = strings.ToLower(strings.TrimSpace(tp))
+		if tp != "" && tp != "module" && !strings.Contains(tp, "javascript") && !strings.Contains(tp, "ecmascript") {
+			return
+		}
+		for _, sub := range ExtractJsLinks(s.Text()) {
+			lc.add(sub, &Link{Tag: "script"})
+		}
+	})
+}
+
+//相对路径和同host的绝对路径返回合并后的url, 其他返回空
 func mergeUrl(base, sub string) string {
+	return scopeUrl(base, base, sub)
+}
+
+//sub相对于base解析, 解析结果与page同host时返回, 其他返回空
+//<base href>指向其他host时, base与page不同
+func scopeUrl(page, base, sub string) string {
 	subU, err := url.Parse(sub)
 	if err != nil {
 		return ""
 	}
-
-	if subU.IsAbs() {
-		//绝对路径且同域
-		if strings.HasPrefix(sub, base) {
-			return sub
-		}
+	baseU, err := url.Parse(base)
+	if err != nil {
 		return ""
 	}
-
-	baseU, err := url.Parse(base)
+	pageU, err := url.Parse(page)
 	if err != nil {
 		return ""
 	}
 
 	mergeU := baseU.ResolveReference(subU)
+	//不能用前缀判断, 否则http://h会匹配http://h.evil.com
+	if (mergeU.Scheme != "http" && mergeU.Scheme != "https") || !sameHost(pageU, mergeU) {
+		return ""
+	}
+	//String会转义path, 但不会转义query中的非ASCII字符
+	mergeU.RawQuery = escapeNonASCII(mergeU.RawQuery)
 	return mergeU.String()
 }
+
+func sameHost(a, b *url.URL) bool {
+	return hostKey(a) == hostKey(b)
+}
+
+//小写的hostname, 非缺省端口附加在后面, http和https的缺省端口视为同一个host
+func hostKey(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	switch port := u.Port(); {
+	case port == "", port == "80" && u.Scheme == "http", port == "443" && u.Scheme == "https":
+		return host
+	default:
+		return host + ":" + port
+	}
+}
+
+func escapeNonASCII(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			fmt.Fprintf(&builder, "%%%02X", s[i])
+			continue
+		}
+		builder.WriteByte(s[i])
+	}
+	return builder.String()
+}
+
+//charset预览不确定时, 用更多内容重新判断<meta charset>
+func utf8Reader(rsp *http.Response, charSet string, certain bool) (io.Reader, error) {
+	reader := bufio.NewReaderSize(rsp.Body, charsetPrescanSize)
+	if !certain {
+		peek, _ := reader.Peek(charsetPrescanSize)
+		if name := prescanCharset(peek); name != "" {
+			charSet = name
+		}
+	}
+	if charSet == "" {
+		return reader, nil
+	}
+	return charset.NewReaderLabel(charSet, reader)
+}
+
+//同charset.DetermineEncoding的<meta>预扫描和utf-8检测, 但不限于前1024字节
+func prescanCharset(content []byte) string {
+	tokenizer := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tag, hasAttr := tokenizer.TagName()
+		if string(tag) != "meta" {
+			continue
+		}
+
+		var httpEquiv, metaContent, label string
+		for hasAttr {
+			var key, val []byte
+			key, val, hasAttr = tokenizer.TagAttr()
+			switch string(key) {
+			case "charset":
+				label = string(val)
+			case "http-equiv":
+				httpEquiv = strings.ToLower(string(val))
+			case "content":
+				metaContent = string(val)
+			}
+		}
+		if label == "" && httpEquiv == "content-type" {
+			if _, params, err := mime.ParseMediaType(metaContent); err == nil {
+				label = params["charset"]
+			}
+		}
+		if _, name := charset.Lookup(label); name != "" {
+			return name
+		}
+	}
+
+	//去掉末尾不完整的字符
+	for i := len(content) - 1; i >= 0 && i > len(content)-4; i-- {
+		if content[i] < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(content[i]) {
+			content = content[:i]
+			break
+		}
+	}
+	if bytes.IndexFunc(content, func(r rune) bool { return r >= utf8.RuneSelf }) >= 0 && utf8.Valid(content) {
+		return "utf-8"
+	}
+	return ""
+}
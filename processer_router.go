@@ -0,0 +1,425 @@
+package spider
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type OptionProcesserRoute func(*ProcesserRoute) error
+
+//匹配的后缀, 取值ContentTypeXXX
+func OptionProcesserRouteSuffixs(suffixs ...string) OptionProcesserRoute {
+	return func(route *ProcesserRoute) error {
+		route.suffixs = suffixs
+		return nil
+	}
+}
+
+//匹配的host, *.example.com匹配所有子域名
+func OptionProcesserRouteHosts(hosts ...string) OptionProcesserRoute {
+	return func(route *ProcesserRoute) error {
+		route.hosts = hosts
+		return nil
+	}
+}
+
+//匹配完整url的正则
+func OptionProcesserRoutePattern(pattern string) OptionProcesserRoute {
+	return func(route *ProcesserRoute) error {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		route.pattern = re
+		return nil
+	}
+}
+
+//匹配path的模板, {name}匹配一段路径, *匹配任意多个非/字符
+//带有query时要求每个参数都存在, 例如/news/{id}.html, /list?page=*
+func OptionProcesserRoutePath(template string) OptionProcesserRoute {
+	return func(route *ProcesserRoute) error {
+		path, err := compilePathTemplate(template)
+		if err != nil {
+			return err
+		}
+		route.path = path
+		return nil
+	}
+}
+
+//在路由的processer之前按rules抽取Item
+func OptionProcesserRouteExtract(rules ...*ExtractRule) OptionProcesserRoute {
+	return func(route *ProcesserRoute) error {
+		processer, err := NewExtractProcesser(rules)
+		if err != nil {
+			return err
+		}
+		route.processers = append([]Processer{processer}, route.processers...)
+		return nil
+	}
+}
+
+//超过深度的请求不再调度
+func OptionProcesserRouteMaxDepth(depth uint) OptionProcesserRoute {
+	return func(route *ProcesserRoute) error {
+		route.maxDepth = &depth
+		return nil
+	}
+}
+
+//覆盖processer设置的优先级
+func OptionProcesserRoutePriority(priority uint) OptionProcesserRoute {
+	return func(route *ProcesserRoute) error {
+		route.priority = &priority
+		return nil
+	}
+}
+
+//是否保存响应, 不影响解析
+func OptionProcesserRouteDownload(download bool) OptionProcesserRoute {
+	return func(route *ProcesserRoute) error {
+		route.download = &download
+		return nil
+	}
+}
+
+//匹配后继续匹配之后的路由, 所有匹配的processer处理同一个body
+func OptionProcesserRouteContinue() OptionProcesserRoute {
+	return func(route *ProcesserRoute) error {
+		route.next = true
+		return nil
+	}
+}
+
+//条件之间是与的关系, 未设置的条件匹配所有
+type ProcesserRoute struct {
+	suffixs []string
+	hosts   []string
+	pattern *regexp.Regexp
+	path    *pathTemplate
+	next    bool
+
+	processers []Processer
+
+	//请求策略, nil表示未设置
+	maxDepth *uint
+	priority *uint
+	download *bool
+}
+
+func (route *ProcesserRoute) match(suffix string, req *http.Request) bool {
+	if len(route.suffixs) > 0 && !stringIn(suffix, route.suffixs) {
+		return false
+	}
+	return route.matchUrl(req)
+}
+
+func (route *ProcesserRoute) matchUrl(req *http.Request) bool {
+	if route.path != nil && !route.path.match(req.URL) {
+		return false
+	}
+	if len(route.hosts) > 0 && !hostMatch(req.URL.Hostname(), route.hosts) {
+		return false
+	}
+	if route.pattern != nil && !route.pattern.MatchString(req.URL.String()) {
+		return false
+	}
+	return true
+}
+
+//按类型、url和host把响应分发给不同的Processer
+type ProcesserRouter struct {
+	routes []*ProcesserRoute
+}
+
+func NewProcesserRouter() *ProcesserRouter {
+	return &ProcesserRouter{}
+}
+
+//按添加顺序匹配, 第一个匹配的路由生效, 除非设置了OptionProcesserRouteContinue
+func (pr *ProcesserRouter) Handle(processers []Processer, options ...OptionProcesserRoute) error {
+	route := &ProcesserRoute{processers: processers}
+	for _, option := range options {
+		if err := option(route); err != nil {
+			return err
+		}
+	}
+	pr.routes = append(pr.routes, route)
+	return nil
+}
+
+func (pr *ProcesserRouter) Match(suffix string, req *http.Request) []Processer {
+	var processers []Processer
+	for _, route := range pr.routes {
+		if !route.match(suffix, req) {
+			continue
+		}
+		processers = append(processers, route.processers...)
+		if !route.next {
+			break
+		}
+	}
+	return processers
+}
+
+//调度前还不知道后缀, 只用url条件匹配, 深度限制和优先级分别取第一个匹配且设置了该项的路由
+//返回false表示请求超过深度限制
+func (pr *ProcesserRouter) schedule(cr *CrawlRequest) bool {
+	var maxDepth, priority *uint
+	for _, route := range pr.routes {
+		if (route.maxDepth == nil || maxDepth != nil) && (route.priority == nil || priority != nil) {
+			continue
+		}
+		if !route.matchUrl(cr.Request) {
+			continue
+		}
+		if maxDepth == nil {
+			maxDepth = route.maxDepth
+		}
+		if priority == nil {
+			priority = route.priority
+		}
+	}
+	if maxDepth != nil && cr.Depth > *maxDepth {
+		return false
+	}
+	if priority != nil {
+		cr.Priority = *priority
+	}
+	return true
+}
+
+//与Match的匹配顺序相同, 取第一个设置了下载策略的路由, 缺省下载
+func (pr *ProcesserRouter) downloadAllow(suffix string, req *http.Request) bool {
+	for _, route := range pr.routes {
+		if !route.match(suffix, req) {
+			continue
+		}
+		if route.download != nil {
+			return *route.download
+		}
+		if !route.next {
+			break
+		}
+	}
+	return true
+}
+
+//每个Processer只Finish一次
+func (pr *ProcesserRouter) Finish() {
+	finished := map[Processer]bool{}
+	for _, route := range pr.routes {
+		for _, processer := range route.processers {
+			if finished[processer] {
+				continue
+			}
+			finished[processer] = true
+			processer.Finish()
+		}
+	}
+}
+
+//多个processer处理同一个body, 每个processer读取自己的副本
+//先返回的processer不影响其他processer和下载
+func processChain(processers []Processer, charSet string, certain bool, rsp *http.Response, reader io.Reader) ([]*CrawlRequest, []Item, []*Link, error) {
+	writers := make([]*io.PipeWriter, len(processers))
+	rsps := make([]*http.Response, len(processers))
+	for i := range processers {
+		pipeReader, pipeWriter := io.Pipe()
+		copied := *rsp
+		copied.Body = pipeReader
+		rsps[i] = &copied
+		writers[i] = pipeWriter
+	}
+
+	//读完reader才返回, 保证下载不会被截断
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		fanout := &fanoutWriter{writers: append([]*io.PipeWriter(nil), writers...)}
+		_, err := io.Copy(fanout, reader)
+		for _, writer := range writers {
+			writer.CloseWithError(err)
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	reqs := make([][]*CrawlRequest, len(processers))
+	items := make([][]Item, len(processers))
+	links := make([][]*Link, len(processers))
+	errs := make([]error, len(processers))
+	for i, processer := range processers {
+		wg.Add(1)
+		go func(i int, processer Processer) {
+			defer wg.Done()
+			switch processer := processer.(type) {
+			case ItemProcesser:
+				reqs[i], items[i], errs[i] = processer.ProcessItems(charSet, certain, rsps[i])
+			case LinkProcesser:
+				reqs[i], links[i], errs[i] = processer.ProcessLinks(charSet, certain, rsps[i])
+			default:
+				reqs[i], errs[i] = processer.Process(charSet, certain, rsps[i])
+			}
+			//processer没有读完时不再写入
+			rsps[i].Body.Close()
+		}(i, processer)
+	}
+	wg.Wait()
+	<-copied
+
+	var allReqs []*CrawlRequest
+	var allItems []Item
+	var allLinks []*Link
+	var result error
+	for i := range processers {
+		allReqs = append(allReqs, reqs[i]...)
+		allItems = append(allItems, items[i]...)
+		allLinks = append(allLinks, links[i]...)
+		if errs[i] != nil && (result == nil || result == ErrNoindex) {
+			result = errs[i]
+		}
+	}
+	return allReqs, allItems, allLinks, result
+}
+
+//写入失败的writer被丢弃, 所有writer都失败后继续消费输入
+type fanoutWriter struct {
+	writers []*io.PipeWriter
+}
+
+func (fw *fanoutWriter) Write(p []byte) (int, error) {
+	alive := fw.writers[:0]
+	for _, writer := range fw.writers {
+		if _, err := writer.Write(p); err == nil {
+			alive = append(alive, writer)
+		}
+	}
+	fw.writers = alive
+	return len(p), nil
+}
+
+func stringIn(s string, ss []string) bool {
+	for _, v := range ss {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func hostMatch(host string, patterns []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+type pathTemplate struct {
+	path  *regexp.Regexp
+	query map[string]*regexp.Regexp //值为nil时只要求参数存在
+}
+
+func compilePathTemplate(template string) (*pathTemplate, error) {
+	rawPath, rawQuery := template, ""
+	if i := strings.Index(template, "?"); i >= 0 {
+		rawPath, rawQuery = template[:i], template[i+1:]
+	}
+	path, err := compileTemplatePart(rawPath, "[^/]+", "[^/]*")
+	if err != nil {
+		return nil, err
+	}
+	pt := &pathTemplate{path: path, query: make(map[string]*regexp.Regexp)}
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		key, value := param, ""
+		if i := strings.Index(param, "="); i >= 0 {
+			key, value = param[:i], param[i+1:]
+		}
+		if value == "" {
+			pt.query[key] = nil
+			continue
+		}
+		if pt.query[key], err = compileTemplatePart(value, ".+", ".*"); err != nil {
+			return nil, err
+		}
+	}
+	return pt, nil
+}
+
+//{name}替换为variable, *替换为wildcard, 其他字符按字面匹配
+func compileTemplatePart(part, variable, wildcard string) (*regexp.Regexp, error) {
+	var builder strings.Builder
+	builder.WriteString("^")
+	for len(part) > 0 {
+		switch part[0] {
+		case '{':
+			end := strings.Index(part, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed variable in template %q", part)
+			}
+			builder.WriteString(variable)
+			part = part[end+1:]
+		case '*':
+			builder.WriteString(wildcard)
+			part = part[1:]
+		default:
+			end := strings.IndexAny(part, "{*")
+			if end < 0 {
+				end = len(part)
+			}
+			builder.WriteString(regexp.QuoteMeta(part[:end]))
+			part = part[end:]
+		}
+	}
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
+
+func (pt *pathTemplate) match(u *url.URL) bool {
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	if !pt.path.MatchString(path) {
+		return false
+	}
+	query := u.Query()
+	for key, value := range pt.query {
+		values, ok := query[key]
+		if !ok {
+			return false
+		}
+		if value == nil {
+			continue
+		}
+		matched := false
+		for _, v := range values {
+			if value.MatchString(v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
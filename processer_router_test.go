@@ -0,0 +1,135 @@
+package spider
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+type recordProcesser struct {
+	name     string
+	body     string
+	finished int
+}
+
+func (rp *recordProcesser) Process(charSet string, certain bool, rsp *http.Response) ([]*CrawlRequest, error) {
+	defer rsp.Body.Close()
+	data, err := ioutil.ReadAll(rsp.Body)
+	rp.body = string(data)
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/"+rp.name, nil)
+	return []*CrawlRequest{CrawlRequestOf(rsp).Child(req, SourceLink)}, err
+}
+
+func (rp *recordProcesser) Finish() {
+	rp.finished++
+}
+
+//go test -v -run=Test_ProcesserRouter
+func Test_ProcesserRouter(t *testing.T) {
+	feed := &recordProcesser{name: "feed"}
+	api := &recordProcesser{name: "api"}
+	audit := &recordProcesser{name: "audit"}
+	html := &recordProcesser{name: "html"}
+
+	router := NewProcesserRouter()
+	router.Handle([]Processer{audit}, OptionProcesserRouteHosts("*.example.com"), OptionProcesserRouteContinue())
+	router.Handle([]Processer{feed}, OptionProcesserRouteSuffixs(ContentTypeRSS, ContentTypeATOM))
+	router.Handle([]Processer{api}, OptionProcesserRouteSuffixs(ContentTypeJSON), OptionProcesserRoutePattern(`/api/`))
+	router.Handle([]Processer{html, feed}, OptionProcesserRouteSuffixs(ContentTypeHTML))
+	if err := router.Handle(nil, OptionProcesserRoutePattern(`(`)); err == nil {
+		t.Errorf("invalid pattern should fail")
+	}
+
+	cases := []struct {
+		suffix string
+		url    string
+		match  []Processer
+	}{
+		{ContentTypeRSS, "http://example.com/feed", []Processer{feed}},
+		{ContentTypeRSS, "http://www.example.com/feed", []Processer{audit, feed}},
+		{ContentTypeJSON, "http://example.com/api/items", []Processer{api}},
+		{ContentTypeJSON, "http://example.com/data.json", nil},
+		{ContentTypeHTML, "http://example.com/", []Processer{html, feed}},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(http.MethodGet, c.url, nil)
+		match := router.Match(c.suffix, req)
+		if len(match) != len(c.match) {
+			t.Errorf("%s %s: expected %d processers, got %d", c.suffix, c.url, len(c.match), len(match))
+			continue
+		}
+		for i := range match {
+			if match[i] != c.match[i] {
+				t.Errorf("%s %s: unexpected processer at %d", c.suffix, c.url, i)
+			}
+		}
+	}
+
+	rsp := newTestResponse(t, "http://example.com/", "text/html", nil)
+	body := newTestResponse(t, "http://example.com/", "text/html", []byte("<html>same body</html>")).Body
+	reqs, _, _, err := processChain([]Processer{html, feed}, "utf-8", true, rsp, body)
+	if err != nil || len(reqs) != 2 || html.body != "<html>same body</html>" || feed.body != html.body {
+		t.Errorf("chained processers should read the same body: %q, %q, %v", html.body, feed.body, err)
+	}
+
+	router.Finish()
+	if feed.finished != 1 || html.finished != 1 {
+		t.Errorf("processers in several routes should finish once")
+	}
+}
+
+//go test -v -run=Test_ProcesserRouterPolicy
+func Test_ProcesserRouterPolicy(t *testing.T) {
+	list := &recordProcesser{name: "list"}
+	detail := &recordProcesser{name: "detail"}
+
+	router := NewProcesserRouter()
+	router.Handle([]Processer{list}, OptionProcesserRoutePath("/list?page=*"),
+		OptionProcesserRoutePriority(PriorityHigh), OptionProcesserRouteDownload(false))
+	router.Handle([]Processer{detail}, OptionProcesserRoutePath("/news/{id}.html"),
+		OptionProcesserRouteMaxDepth(2), OptionProcesserRouteExtract(&ExtractRule{
+			Fields: []Field{{Name: "title", Css: "h1"}},
+		}))
+	if err := router.Handle(nil, OptionProcesserRoutePath("/news/{id")); err == nil {
+		t.Errorf("unclosed variable should fail")
+	}
+
+	cases := []struct {
+		url      string
+		match    bool
+		depth    uint
+		schedule bool
+		priority uint
+		download bool
+	}{
+		{"http://example.com/list?page=2&sort=date", true, 5, true, PriorityHigh, false},
+		{"http://example.com/list", false, 1, true, PriorityNormal, true},
+		{"http://example.com/news/42.html", true, 2, true, PriorityNormal, true},
+		{"http://example.com/news/42.html", true, 3, false, PriorityNormal, true},
+		{"http://example.com/news/a/42.html", false, 3, true, PriorityNormal, true},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(http.MethodGet, c.url, nil)
+		if match := router.Match(ContentTypeHTML, req); (len(match) > 0) != c.match {
+			t.Errorf("%s: expected match %v, got %v", c.url, c.match, match)
+		}
+		cr := NewCrawlRequest(req)
+		cr.Depth = c.depth
+		if router.schedule(cr) != c.schedule || cr.Priority != c.priority {
+			t.Errorf("%s at depth %d: unexpected schedule, priority %d", c.url, c.depth, cr.Priority)
+		}
+		if router.downloadAllow(ContentTypeHTML, req) != c.download {
+			t.Errorf("%s: expected download %v", c.url, c.download)
+		}
+	}
+
+	//抽取的Item和路由的processer处理同一个body
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/news/42.html", nil)
+	processers := router.Match(ContentTypeHTML, req)
+	rsp := newTestResponse(t, req.URL.String(), "text/html", nil)
+	body := newTestResponse(t, req.URL.String(), "text/html", []byte("<h1>title</h1>")).Body
+	_, items, _, err := processChain(processers, "utf-8", true, rsp, body)
+	if err != nil || len(items) != 1 || items[0]["title"] != "title" || detail.body != "<h1>title</h1>" {
+		t.Errorf("unexpected items %v, err %v", items, err)
+	}
+}
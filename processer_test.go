@@ -0,0 +1,139 @@
+package spider
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func newTestResponse(t *testing.T, rawurl, contentType string, body []byte) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make(http.Header)
+	header.Set("Content-Type", contentType)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+}
+
+//go test -v -run=Test_DomProcesserCharset
+func Test_DomProcesserCharset(t *testing.T) {
+	page := `<html><head>` + string(bytes.Repeat([]byte("<!-- padding -->"), 100)) +
+		`<meta charset="gbk"></head><body><a href="/新闻/列表.html?类别=体育">新闻</a></body></html>`
+	gbk, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(page))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//<meta charset>在1024字节之后, 预览判断为windows-1252
+	charSet, certain := httpResponseCharset(gbk[:1024], "text/html")
+	rsp := newTestResponse(t, "http://example.com/", "text/html", gbk)
+	reqs, err := NewDomProcesser().Process(charSet, certain, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "http://example.com/%E6%96%B0%E9%97%BB/%E5%88%97%E8%A1%A8.html?%E7%B1%BB%E5%88%AB=%E4%BD%93%E8%82%B2"
+	if len(reqs) != 1 || reqs[0].URL.String() != expected {
+		t.Errorf("expected %s, got %v", expected, reqs)
+	}
+}
+
+//go test -v -run=Test_DomProcesserRobots
+func Test_DomProcesserRobots(t *testing.T) {
+	page := `<html><head><base href="/docs/"><meta name="robots" content="NOINDEX"></head><body>
+<a href="intro.html">intro</a><a href="/login" rel="nofollow noopener">login</a></body></html>`
+
+	rsp := newTestResponse(t, "http://example.com/index.html", "text/html", []byte(page))
+	reqs, err := NewDomProcesser().Process("utf-8", true, rsp)
+	if err != ErrNoindex {
+		t.Errorf("expected noindex, got %v", err)
+	}
+	links := map[string]bool{}
+	for _, req := range reqs {
+		links[req.URL.String()] = true
+	}
+	if !links["http://example.com/docs/intro.html"] || links["http://example.com/intro.html"] {
+		t.Errorf("links should be resolved against <base href>: %v", links)
+	}
+	if links["http://example.com/login"] {
+		t.Errorf("rel=nofollow link should be skipped: %v", links)
+	}
+
+	rsp = newTestResponse(t, "http://example.com/index.html", "text/html", []byte(page))
+	rsp.Header.Set("X-Robots-Tag", "nofollow")
+	reqs, err = NewDomProcesser(OptionDomProcesserNoindex(false, true)).Process("utf-8", true, rsp)
+	if err != nil || len(reqs) != 0 {
+		t.Errorf("nofollow page should yield no links and noindex should be ignored: %v, %v", reqs, err)
+	}
+}
+
+//go test -v -run=Test_DomProcesserBaseHost
+func Test_DomProcesserBaseHost(t *testing.T) {
+	page := `<html><head><base href="http://evil.com/"></head><body>
+<a href="/p">p</a><a href="http://example.com/q">q</a></body></html>`
+
+	rsp := newTestResponse(t, "http://example.com/", "text/html", []byte(page))
+	reqs, links, err := NewDomProcesser().ProcessLinks("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(reqs) != 1 || reqs[0].URL.String() != "http://example.com/q" {
+		t.Errorf("only links on the page host should be followed: %v", reqs)
+	}
+	external := false
+	for _, link := range links {
+		if link.Target == "http://evil.com/p" {
+			external = !link.Followed && link.Reason == LinkReasonExternal
+		}
+	}
+	if !external {
+		t.Errorf("link resolved to another host by <base href> should be recorded as external: %v", links)
+	}
+}
+
+//go test -v -run=Test_DomProcesserRules
+func Test_DomProcesserRules(t *testing.T) {
+	page := `<html><head>
+<meta http-equiv="Refresh" content="5; URL='/refreshed'">
+<link rel="next" href="/page/2"><link rel="alternate" hreflang="en" href="/en/">
+</head><body>
+<img src="/a.png" data-src="/lazy.png" srcset="/a-1x.png 1x, /a,2x.png 2x, /a-3x.png">
+<picture><source srcset="/b.webp 480w, /b-big.webp 800w"></picture>
+<video src="/v.mp4" poster="/poster.jpg"></video>
+<a href="/a.png">duplicate</a>
+</body></html>`
+
+	rsp := newTestResponse(t, "http://example.com/", "text/html", []byte(page))
+	reqs, err := NewDomProcesser().Process("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	links := map[string]int{}
+	for _, req := range reqs {
+		links[req.URL.Path]++
+	}
+	for _, path := range []string{"/refreshed", "/page/2", "/en/", "/a.png", "/lazy.png",
+		"/a-1x.png", "/a,2x.png", "/a-3x.png", "/b.webp", "/b-big.webp", "/v.mp4", "/poster.jpg"} {
+		if links[path] != 1 {
+			t.Errorf("expected %s once, got %d", path, links[path])
+		}
+	}
+
+	rsp = newTestResponse(t, "http://example.com/", "text/html", []byte(page))
+	reqs, err = NewDomProcesser(OptionDomProcesserSelectors([]string{"video"})).Process("utf-8", true, rsp)
+	if err != nil || len(reqs) != 1 || reqs[0].URL.Path != "/v.mp4" {
+		t.Errorf("custom selectors should be honoured: %v, %v", reqs, err)
+	}
+}
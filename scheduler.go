@@ -1,36 +1,56 @@
 package spider
 
-import (
-	"net/http"
+//请求优先级, CrawlRequest.Priority, 缺省为PriorityNormal
+const (
+	PriorityLow = iota
+	PriorityNormal
+	PriorityHigh
 )
 
 type Scheduler interface {
-	Push(*http.Request)
-	Poll() *http.Request
+	Push(*CrawlRequest)
+	Poll() *CrawlRequest
 
 	Rest() int
 }
 
+//每个优先级一个队列, 优先取高优先级
 type SchedulerChan struct {
-	reqs chan *http.Request
+	reqs []chan *CrawlRequest
 }
 
 func NewSchedulerChan() *SchedulerChan {
-	reqs := make(chan *http.Request, 102400)
+	reqs := make([]chan *CrawlRequest, PriorityHigh+1)
+	for i := range reqs {
+		reqs[i] = make(chan *CrawlRequest, 102400)
+	}
 	return &SchedulerChan{reqs}
 }
 
-func (sc *SchedulerChan) Push(req *http.Request) {
-	sc.reqs <- req
+//超出范围的优先级按PriorityNormal处理
+func (sc *SchedulerChan) Push(req *CrawlRequest) {
+	priority := req.Priority
+	if priority > PriorityHigh {
+		priority = PriorityNormal
+	}
+	sc.reqs[priority] <- req
 }
 
-func (sc *SchedulerChan) Poll() *http.Request {
-	if len(sc.reqs) == 0 {
-		return nil
+func (sc *SchedulerChan) Poll() *CrawlRequest {
+	for i := len(sc.reqs) - 1; i >= 0; i-- {
+		select {
+		case req := <-sc.reqs[i]:
+			return req
+		default:
+		}
 	}
-	return <-sc.reqs
+	return nil
 }
 
 func (sc *SchedulerChan) Rest() int {
-	return len(sc.reqs)
+	rest := 0
+	for _, reqs := range sc.reqs {
+		rest += len(reqs)
+	}
+	return rest
 }
@@ -0,0 +1,137 @@
+package spider
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+)
+
+const (
+	SniffPolicyHeader   = iota //信任Content-Type, 缺失或无法识别时才嗅探
+	SniffPolicyContent         //信任嗅探结果, 嗅探不出具体类型时使用Content-Type
+	SniffPolicyMismatch        //两者不兼容时以嗅探为准
+)
+
+const (
+	SniffPolicyDefault = SniffPolicyHeader
+)
+
+//net/http嗅探不出具体类型时的兜底结果
+var sniffGenericTypes = map[string]bool{
+	"application/octet-stream": true,
+	"text/plain":               true,
+}
+
+//可以互相替代的标记语言类型
+var sniffMarkupTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"application/xml":       true,
+}
+
+type magic struct {
+	offset    int
+	signature []byte
+	mediaType string
+}
+
+//http.DetectContentType之外的常见格式
+//https://mimesniff.spec.whatwg.org/
+var magics = []magic{
+	{0, []byte("%PDF-"), "application/pdf"},
+	{0, []byte("wOF2"), "font/woff2"},
+	{0, []byte("wOFF"), "font/woff"},
+	{0, []byte("OTTO"), "font/otf"},
+	{0, []byte("\x00\x01\x00\x00\x00"), "font/ttf"},
+	{0, []byte("7z\xBC\xAF\x27\x1C"), "application/x-7z-compressed"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("Rar!\x1A\x07"), "application/vnd.rar"},
+	{0, []byte("\x1F\x8B\x08"), "application/gzip"},
+	{0, []byte("II*\x00"), "image/tiff"},
+	{0, []byte("MM\x00*"), "image/tiff"},
+	{0, []byte("\x00\x00\x01\x00"), "image/vnd.microsoft.icon"},
+	{4, []byte("ftypavif"), "image/avif"},
+	{4, []byte("ftypisom"), "video/mp4"},
+	{4, []byte("ftypmp42"), "video/mp4"},
+	{4, []byte("ftyp3gp"), "video/3gpp"},
+	{257, []byte("ustar"), "application/x-tar"},
+}
+
+//zip容器按第一个条目(mimetype)或目录名区分具体类型
+var zipContainers = []struct {
+	marker    []byte
+	mediaType string
+}{
+	{[]byte("mimetypeapplication/epub+zip"), "application/epub+zip"},
+	{[]byte("mimetypeapplication/vnd.oasis.opendocument.text"), "application/vnd.oasis.opendocument.text"},
+	{[]byte("mimetypeapplication/vnd.oasis.opendocument.spreadsheet"), "application/vnd.oasis.opendocument.spreadsheet"},
+	{[]byte("mimetypeapplication/vnd.oasis.opendocument.presentation"), "application/vnd.oasis.opendocument.presentation"},
+	{[]byte("word/"), "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{[]byte("xl/"), "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{[]byte("ppt/"), "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{[]byte("META-INF/MANIFEST.MF"), "application/java-archive"},
+}
+
+//返回不带参数的媒体类型, 总能返回结果
+func detectContentType(data []byte) string {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		for _, container := range zipContainers {
+			if bytes.Contains(data, container.marker) {
+				return container.mediaType
+			}
+		}
+		return "application/zip"
+	}
+	for _, m := range magics {
+		if len(data) >= m.offset+len(m.signature) &&
+			bytes.Equal(data[m.offset:m.offset+len(m.signature)], m.signature) {
+			return m.mediaType
+		}
+	}
+	return normalizeMediaType(http.DetectContentType(data))
+}
+
+//参数格式错误时仍保留媒体类型
+func declaredContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil && err != mime.ErrInvalidMediaParameter {
+		return ""
+	}
+	return mediaType
+}
+
+//根据策略在声明类型和嗅探类型中选择
+func sniffContentType(registry *MimeRegistry, policy uint, declared, detected string) string {
+	generic := sniffGenericTypes[detected]
+	if !registry.registered(declared) {
+		//仅能通配的声明类型比兜底的嗅探结果更具体
+		if _, ok := registry.Extension(declared); ok && generic {
+			return declared
+		}
+		return detected
+	}
+
+	switch policy {
+	case SniffPolicyContent:
+		if !generic {
+			return detected
+		}
+	case SniffPolicyMismatch:
+		if !generic && !sniffCompatible(registry, declared, detected) {
+			return detected
+		}
+	}
+	return declared
+}
+
+func sniffCompatible(registry *MimeRegistry, declared, detected string) bool {
+	declared = registry.Canonical(declared)
+	detected = registry.Canonical(detected)
+	if declared == detected {
+		return true
+	}
+	if sniffMarkupTypes[detected] && (sniffMarkupTypes[declared] || registry.structuredBase(declared) == "application/xml") {
+		return true
+	}
+	return registry.structuredBase(declared) == detected
+}
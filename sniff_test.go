@@ -0,0 +1,46 @@
+package spider
+
+import (
+	"testing"
+)
+
+//go test -v -run=Test_HttpResponseContentType
+func Test_HttpResponseContentType(t *testing.T) {
+	docx := append([]byte("PK\x03\x04"), []byte("\x14\x00\x06\x00[Content_Types].xml...word/document.xml")...)
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+	html := []byte("<!DOCTYPE html><html><body>hi</body></html>")
+
+	cases := []struct {
+		policy      uint
+		data        []byte
+		contentType string
+		suffix      string
+	}{
+		//缺失或无法解析的头回退到嗅探
+		{SniffPolicyHeader, html, "", ContentTypeHTML},
+		{SniffPolicyHeader, []byte("%PDF-1.7"), "application/", ContentTypePDF},
+		{SniffPolicyHeader, html, "text/html; charset", ContentTypeHTML},
+		{SniffPolicyHeader, []byte("plain text"), "text/x-unknown", ContentTypeTXT},
+		{SniffPolicyHeader, docx, "application/x-unknown", ContentTypeDOCX},
+
+		//头与内容不符
+		{SniffPolicyHeader, webp, "image/png", ContentTypePNG},
+		{SniffPolicyContent, webp, "image/png", ContentTypeWEBP},
+		{SniffPolicyMismatch, webp, "image/png", ContentTypeWEBP},
+		{SniffPolicyContent, docx, "application/zip", ContentTypeDOCX},
+		{SniffPolicyMismatch, []byte("wOF2\x00\x01"), "application/octet-stream", ContentTypeWOFF2},
+
+		//兼容的类型不算不符
+		{SniffPolicyContent, html, "application/xhtml+xml", ContentTypeHTML},
+		{SniffPolicyMismatch, html, "application/xhtml+xml", ContentTypeXHTML},
+		{SniffPolicyMismatch, []byte("<?xml version=\"1.0\"?><rss></rss>"), "application/rss+xml", ContentTypeRSS},
+		{SniffPolicyMismatch, []byte("some text"), "text/css", ContentTypeCSS},
+	}
+	for _, c := range cases {
+		suffix, _, detected, err := httpResponseContentType(DefaultMimeRegistry, c.policy, c.data, c.contentType)
+		if err != nil || suffix != c.suffix {
+			t.Errorf("policy %d, content-type %q, detected %s: expected %s, got %s, err: %v",
+				c.policy, c.contentType, detected, c.suffix, suffix, err)
+		}
+	}
+}
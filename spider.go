@@ -2,14 +2,17 @@ package spider
 
 import (
 	"bytes"
-	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"mime"
 	"net/http"
-	"strings"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cihub/seelog"
@@ -30,6 +33,12 @@ const (
 	SleepMaxDefault  = 1000
 
 	DownloadPathDefault = "/tmp/tamper"
+
+	RetryMaxDefault           = 2
+	RetryDelayDefault         = time.Second
+	RetryDelayMax             = time.Minute
+	UnhealthyThresholdDefault = 5
+	UnhealthyCooldownDefault  = 30 * time.Second
 )
 
 type OptionSpider func(*Spider)
@@ -46,6 +55,71 @@ func OptionSpiderProcesser(processer Processer) OptionSpider {
 	}
 }
 
+//处理text/css响应
+func OptionSpiderCssProcesser(processer Processer) OptionSpider {
+	return func(spider *Spider) {
+		spider.cssProcesser = processer
+	}
+}
+
+//处理javascript响应
+func OptionSpiderJsProcesser(processer Processer) OptionSpider {
+	return func(spider *Spider) {
+		spider.jsProcesser = processer
+	}
+}
+
+//rss和atom的处理器, 设置后rss和atom响应交给它处理
+//条目链接通常指向其他host, 不受同host限制, 因此默认不处理feed
+func OptionSpiderFeedProcesser(processer Processer) OptionSpider {
+	return func(spider *Spider) {
+		spider.feedProcesser = processer
+	}
+}
+
+//接口数据的处理器, 设置后json响应交给它处理
+func OptionSpiderJsonProcesser(processer Processer) OptionSpider {
+	return func(spider *Spider) {
+		spider.jsonProcesser = processer
+	}
+}
+
+//html和xml同时交给processer抽取Item
+func OptionSpiderExtractProcesser(processer *ExtractProcesser) OptionSpider {
+	return func(spider *Spider) {
+		spider.extractProcesser = processer
+	}
+}
+
+//ItemProcesser产出的Item流经pipeline, pipeline随Run打开和关闭
+func OptionSpiderItemPipeline(pipeline *ItemPipeline) OptionSpider {
+	return func(spider *Spider) {
+		spider.pipeline = pipeline
+	}
+}
+
+//每个请求结束时导出Result, Run结束时关闭exporter
+func OptionSpiderResultExporter(exporter Exporter) OptionSpider {
+	return func(spider *Spider) {
+		spider.exporter = exporter
+	}
+}
+
+//每个请求结束时逐条导出Result.Links, Run结束时关闭exporter
+func OptionSpiderLinkExporter(exporter Exporter) OptionSpider {
+	return func(spider *Spider) {
+		spider.linkExporter = exporter
+	}
+}
+
+//设置后忽略OptionSpiderProcesser, OptionSpiderCssProcesser, OptionSpiderJsProcesser,
+//OptionSpiderFeedProcesser, OptionSpiderJsonProcesser和OptionSpiderExtractProcesser, 由router决定所有的路由
+func OptionSpiderProcesserRouter(router *ProcesserRouter) OptionSpider {
+	return func(spider *Spider) {
+		spider.router = router
+	}
+}
+
 func OptionSpiderDownloader(downloader Downloader) OptionSpider {
 	return func(spider *Spider) {
 		spider.downloader = downloader
@@ -105,12 +179,86 @@ func OptionSpiderRequestTimeout(timeout time.Duration) OptionSpider {
 	}
 }
 
+func OptionSpiderMimeRegistry(mimeRegistry *MimeRegistry) OptionSpider {
+	return func(spider *Spider) {
+		spider.mimeRegistry = mimeRegistry
+	}
+}
+
+//Content-Type缺失或与内容不符时的处理, 取值SniffPolicyXXX
+func OptionSpiderSniffPolicy(policy uint) OptionSpider {
+	return func(spider *Spider) {
+		if policy > SniffPolicyMismatch {
+			return
+		}
+		spider.sniffPolicy = policy
+	}
+}
+
+func OptionSpiderStatusPolicy(statusPolicy StatusPolicy) OptionSpider {
+	return func(spider *Spider) {
+		spider.statusPolicy = statusPolicy
+	}
+}
+
+//同一请求因状态码重试的最大次数
+func OptionSpiderRetry(max uint) OptionSpider {
+	return func(spider *Spider) {
+		spider.retryMax = max
+	}
+}
+
+//第n次重试前等待delay*2^n, 响应带有Retry-After时以Retry-After为准, 均不超过RetryDelayMax
+func OptionSpiderRetryDelay(delay time.Duration) OptionSpider {
+	return func(spider *Spider) {
+		spider.retryDelay = delay
+	}
+}
+
+//host连续返回不健康状态码达到threshold次后, 不再请求该host
+func OptionSpiderUnhealthyThreshold(threshold uint) OptionSpider {
+	return func(spider *Spider) {
+		if threshold == 0 {
+			threshold = 1
+		}
+		spider.unhealthyThreshold = threshold
+	}
+}
+
+//host被标记为不健康后, 每隔cooldown放行一个探测请求, 探测成功则恢复
+func OptionSpiderUnhealthyCooldown(cooldown time.Duration) OptionSpider {
+	return func(spider *Spider) {
+		spider.unhealthyCooldown = cooldown
+	}
+}
+
 func OptionSpiderResponseChunkedAllowed(allowed bool) OptionSpider {
 	return func(spider *Spider) {
 		spider.rspChunkedAllowed = allowed
 	}
 }
 
+//GET之前先发送HEAD, 用响应头做Filter检查, 通过后才GET
+func OptionSpiderHeadPreflight(enabled bool) OptionSpider {
+	return func(spider *Spider) {
+		spider.headPreflight = enabled
+	}
+}
+
+//超过Filter.SizeLimit的响应是否保留截断后的内容, 否则删除
+func OptionSpiderResponseTruncatedAllowed(allowed bool) OptionSpider {
+	return func(spider *Spider) {
+		spider.rspTruncatedAllowed = allowed
+	}
+}
+
+//队列为空时Run不退出, 直到调用Stop, 用于FeedPoller等持续推送请求的场景
+func OptionSpiderKeepalive(keepalive bool) OptionSpider {
+	return func(spider *Spider) {
+		spider.keepalive = keepalive
+	}
+}
+
 //不包含content-type嗅探
 type Spider struct {
 	results map[string]*Result
@@ -119,13 +267,37 @@ type Spider struct {
 	defaultHeader http.Header
 	checkRedirect func(req *http.Request, via []*http.Request) error
 	timeout       time.Duration
-
-	rspChunkedAllowed bool
+	headPreflight bool
+
+	rspChunkedAllowed   bool
+	rspTruncatedAllowed bool
+	mimeRegistry        *MimeRegistry
+	sniffPolicy         uint
+
+	//状态码处理
+	statusPolicy       StatusPolicy
+	retryMax           uint
+	retryDelay         time.Duration
+	unhealthyThreshold uint
+	unhealthyCooldown  time.Duration
+	hosts              map[string]*hostHealth
+	hostMutex          sync.Mutex
+	delayed            int32 //等待重试的请求数
 
 	//对外模块
-	filter     Filter
-	processer  Processer
-	downloader Downloader
+	filter           Filter
+	processer        Processer
+	cssProcesser     Processer
+	jsProcesser      Processer
+	feedProcesser    Processer
+	jsonProcesser    Processer
+	extractProcesser *ExtractProcesser
+	router           *ProcesserRouter
+	downloader       Downloader
+	pipeline         *ItemPipeline
+	exporter         Exporter
+	linkExporter     Exporter
+	callbacks        *CallbackProcesser
 
 	//下个版本可以废除
 	scheduler   Scheduler
@@ -133,6 +305,10 @@ type Spider struct {
 
 	concu uint32 //并发
 
+	keepalive bool
+	stop      chan struct{}
+	stopOnce  sync.Once
+
 	//sleep duration in millisecond
 	sleepMin  uint
 	sleepMax  uint
@@ -140,16 +316,23 @@ type Spider struct {
 }
 
 type Result struct {
-	Error string `json:"error,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Failure string `json:"failure,omitempty"` //请求失败的类型, 取值FailureXXX
 
 	//request result
-	Req *http.Request  `json:"-"`
-	Rsp *http.Response `json:"-"`
+	Req        *CrawlRequest  `json:"-"`
+	Rsp        *http.Response `json:"-"`
+	StatusCode int            `json:"status_code,omitempty"`
+	Retry      uint           `json:"retry,omitempty"`
 
 	//inner parser result
-	Size    int64  `json:"size,omitempty"`
-	Suffix  string `json:"suffix,omitempty"`
-	CharSet string `json:"charset,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	Suffix       string `json:"suffix,omitempty"`
+	CharSet      string `json:"charset,omitempty"`
+	Truncated    bool   `json:"truncated,omitempty"`
+	Noindex      bool   `json:"noindex,omitempty"`
+	DeclaredType string `json:"declared_type,omitempty"`
+	DetectedType string `json:"detected_type,omitempty"`
 
 	//download result
 	UrlPath  *string `json:"url_path,omitempty"`
@@ -157,19 +340,31 @@ type Result struct {
 	BodyPath *string `json:"body_path,omitempty"`
 
 	//processer result
-	Depth uint     `json:"depth"`
-	Subs  []string `json:"subs,omitempty"`
+	Depth  uint     `json:"depth"`
+	Parent string   `json:"parent,omitempty"`
+	Source string   `json:"source,omitempty"`
+	Subs   []string `json:"subs,omitempty"`
+	Items  []Item   `json:"items,omitempty"`
+	Links  []*Link  `json:"links,omitempty"`
 }
 
 func NewSpider(options ...OptionSpider) *Spider {
 	spider := &Spider{
-		defaultHeader:     make(http.Header),
-		results:           make(map[string]*Result),
-		rspChunkedAllowed: true,
-		concu:             SpiderConcuDefault,
-		sleepMin:          SleepMinDefault,
-		sleepMax:          SleepMaxDefault,
-		sleepType:         SleepTypeDefault,
+		defaultHeader:      make(http.Header),
+		results:            make(map[string]*Result),
+		rspChunkedAllowed:  true,
+		sniffPolicy:        SniffPolicyDefault,
+		retryMax:           RetryMaxDefault,
+		retryDelay:         RetryDelayDefault,
+		unhealthyThreshold: UnhealthyThresholdDefault,
+		unhealthyCooldown:  UnhealthyCooldownDefault,
+		hosts:              make(map[string]*hostHealth),
+		stop:               make(chan struct{}),
+		callbacks:          NewCallbackProcesser(),
+		concu:              SpiderConcuDefault,
+		sleepMin:           SleepMinDefault,
+		sleepMax:           SleepMaxDefault,
+		sleepType:          SleepTypeDefault,
 	}
 
 	for _, option := range options {
@@ -181,21 +376,36 @@ func NewSpider(options ...OptionSpider) *Spider {
 	if spider.resourceMgr == nil {
 		spider.resourceMgr = NewResourceChan(spider.concu)
 	}
-	if spider.processer == nil {
-		spider.processer = NewDomProcesser()
+	if spider.router == nil {
+		spider.router = spider.defaultRouter()
 	}
 	if spider.downloader == nil {
 		spider.downloader = NewFileDownloader(DownloadPathDefault)
 	}
+	if spider.mimeRegistry == nil {
+		spider.mimeRegistry = DefaultMimeRegistry
+	}
+	if spider.statusPolicy == nil {
+		spider.statusPolicy = NewRangeStatusPolicy()
+	}
 	return spider
 }
 
 func (spider *Spider) Run() *Spider {
+	if spider.pipeline != nil {
+		if err := spider.pipeline.Open(); err != nil {
+			seelog.Errorf("Spider::Run | item pipeline open err: %s", err)
+			//pipeline已回滚打开的Stage, 这里关闭其他模块
+			spider.finish()
+			return spider
+		}
+	}
+
 	for {
 		req := spider.scheduler.Poll()
 		if req == nil {
-			if spider.resourceMgr.Used() == uint32(0) {
-				spider.processer.Finish()
+			if spider.resourceMgr.Used() == uint32(0) && atomic.LoadInt32(&spider.delayed) == 0 && (!spider.keepalive || spider.stopped()) {
+				spider.finish()
 				break
 			}
 			time.Sleep(500 * time.Millisecond)
@@ -212,40 +422,95 @@ func (spider *Spider) Run() *Spider {
 
 		spider.resourceMgr.Acquire()
 
-		go func(req *http.Request) {
+		go func(cr *CrawlRequest) {
 			defer spider.resourceMgr.Release()
 
-			url := req.URL.String()
-			if spider.exists(url) {
+			url := requestKey(cr.Request)
+			//重试的请求覆盖之前的结果
+			if cr.Retry == 0 && spider.exists(url) {
 				return
 			}
-			result := &Result{Req: req}
-			spider.record(url, result)
+			//Processer通过CrawlRequestOf取得cr
+			cr.bind()
+			req := cr.Request
+
+			result := &Result{
+				Req:    cr,
+				Retry:  cr.Retry,
+				Depth:  cr.Depth,
+				Parent: cr.Parent,
+				Source: cr.Source,
+			}
+			//处理期间记录一份不再修改的副本, 完成后在锁内替换为最终结果
+			//这样Result、Stats和LinkGraph在运行中读取到的Result都不会被并发修改
+			pending := *result
+			spider.record(url, &pending)
+			//重试前的中间结果不导出, 只导出最后一次请求的结果
+			final := true
+			defer func() {
+				spider.record(url, result)
+				if final {
+					spider.export(result)
+				}
+			}()
 
 			defer func() {
 				spider.sleep()
 			}()
 
-			depth, ok := req.Context().Value("depth").(uint)
-			if !ok {
-				result.Error = "no depth in http request context"
+			if !spider.healthy(req.URL.Host) {
+				result.Error = "unhealthy host"
 				return
 			}
-			result.Depth = depth
 
 			client := &http.Client{
 				CheckRedirect: spider.checkRedirect,
 				Timeout:       spider.timeout,
 			}
+			if spider.headPreflight && !spider.preflight(client, req) {
+				result.Error = "filter rejected request in head preflight"
+				return
+			}
 			rsp, err := client.Do(req)
 			if err != nil {
 				seelog.Errorf("Spider::Run | client do err: %s", err)
 				result.Error = err.Error()
+				result.Failure = requestFailure(err)
 				return
 			}
 			closer := rsp.Body
 			defer closer.Close()
 
+			result.StatusCode = rsp.StatusCode
+			spider.checkHealth(req.URL.Host, rsp.StatusCode)
+
+			if spider.statusPolicy.RetryAllow(rsp.StatusCode) && cr.Retry < spider.retryMax {
+				retried, err := cr.retry()
+				if err != nil {
+					result.Error = err.Error()
+					return
+				}
+				result.Error = fmt.Sprintf("status code %d, retry scheduled", rsp.StatusCode)
+				final = false
+				//等待期间不占用连接和并发数, delayed计数保证Run不会在重试之前退出
+				delay := spider.retryWait(rsp, cr.Retry)
+				closer.Close()
+				atomic.AddInt32(&spider.delayed, 1)
+				go func() {
+					spider.wait(delay)
+					spider.scheduler.Push(retried)
+					atomic.AddInt32(&spider.delayed, -1)
+				}()
+				return
+			}
+
+			download := spider.statusPolicy.DownloadAllow(rsp.StatusCode)
+			process := spider.statusPolicy.ProcessAllow(rsp.StatusCode)
+			if !download && !process {
+				result.Error = fmt.Sprintf("status code %d rejected", rsp.StatusCode)
+				return
+			}
+
 			if httpResponseChunked(rsp.TransferEncoding) && !spider.rspChunkedAllowed {
 				result.Error = "unsupported chunked transfer encoding"
 				return
@@ -257,9 +522,14 @@ func (spider *Spider) Run() *Spider {
 			preview = preview[:n]
 
 			if n > 0 {
-				suffix, err = httpResponseContentType(
+				var declared, detected string
+				suffix, declared, detected, err = httpResponseContentType(
+					spider.mimeRegistry,
+					spider.sniffPolicy,
 					preview,
 					rsp.Header.Get("Content-Type"))
+				result.DeclaredType = declared
+				result.DetectedType = detected
 				if err != nil {
 					seelog.Errorf("Spider::Run | http response content type err: %s", err)
 					result.Error = err.Error()
@@ -272,33 +542,52 @@ func (spider *Spider) Run() *Spider {
 				result.Size = rsp.ContentLength
 				result.Suffix = suffix
 				result.CharSet = charSet
+				//Processer通过ResponseSuffix取得嗅探后的类型
+				bindResponseSuffix(rsp, suffix)
 
 				if !spider.filterCheck(req.Method, result.Size, result.Suffix) {
 					result.Error = "filter rejected request"
 					return
 				}
 
-				mergeReader := io.MultiReader(bytes.NewBuffer(preview), rsp.Body)
+				//chunked或未知长度的响应只能在读取时限制大小
+				mergeReader := &limitedReader{
+					reader: io.MultiReader(bytes.NewBuffer(preview), rsp.Body),
+					limit:  spider.sizeLimit(result.Suffix),
+				}
 
 				//downloader and processer
 				wg := sync.WaitGroup{}
 				var urlPath, hdrPath, bodyPath *string
-				var reqs []*http.Request
+				var reqs []*CrawlRequest
+				var items []Item
+				var links []*Link
 				var errD, errP error
 
-				switch result.Suffix {
-				case ContentTypeHTML, ContentTypeHTM, ContentTypeXHTML, ContentTypeXML:
+				download = download && spider.router.downloadAllow(result.Suffix, req)
+				processers := spider.router.Match(result.Suffix, req)
+				//回调不受router限制, 先于其他processer执行
+				if spider.callbacks.active() {
+					processers = append([]Processer{spider.callbacks}, processers...)
+				}
+				if len(processers) == 0 {
+					process = false
+				}
+
+				switch {
+				case process && download:
 					//既解析又下载
 					pipeReader, pipeWriter := io.Pipe()
 					teeReader := io.TeeReader(mergeReader, pipeWriter)
-					rsp.Body = pipeReader
 					wg.Add(2)
 					go func() {
 						defer wg.Done()
-						reqs, errP = spider.processer.Process(
+						reqs, items, links, errP = processChain(
+							processers,
 							charSet,
 							certain,
-							rsp)
+							rsp,
+							pipeReader)
 						pipeReader.Close()
 					}()
 
@@ -313,7 +602,19 @@ func (spider *Spider) Run() *Spider {
 						pipeWriter.Close()
 					}()
 
-				default: //仅下载
+				case process: //仅解析
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						reqs, items, links, errP = processChain(
+							processers,
+							charSet,
+							certain,
+							rsp,
+							mergeReader)
+					}()
+
+				case download: //仅下载
 					wg.Add(1)
 					go func() {
 						defer wg.Done()
@@ -327,25 +628,82 @@ func (spider *Spider) Run() *Spider {
 				}
 				wg.Wait()
 
+				if result.Size < 0 {
+					result.Size = mergeReader.read
+				}
+				if mergeReader.exceeded {
+					if !spider.rspTruncatedAllowed {
+						if err = spider.downloader.Remove(urlPath, hdrPath, bodyPath); err != nil {
+							seelog.Errorf("Spider::Run | downloader remove err: %s", err)
+						}
+						result.Error = "response body exceeds size limit"
+						return
+					}
+					result.Truncated = true
+				}
+
+				//noindex的页面不保留下载内容, 但仍然继续发现的链接
+				noindex := errP == ErrNoindex
+				if noindex {
+					errP = nil
+				}
+
 				if errP != nil {
 					seelog.Errorf("Spider::Run | processer err: %s", errP)
 					result.Error = errP.Error()
 					return
 				}
 
+				//下载失败不影响已经抽取的Item和链接
+				//ItemPipeline.Push会复制Item, Stage的修改不影响result.Items
+				result.Items = items
+				result.Links = links
+				if spider.pipeline != nil && len(items) > 0 {
+					spider.pipeline.Push(req.URL.String(), items...)
+				}
+
 				if errD != nil {
 					seelog.Errorf("Spider::Run | downloader err: %s", errD)
 					result.Error = errD.Error()
 					return
 				}
-				result.UrlPath = urlPath
-				result.HdrPath = hdrPath
-				result.BodyPath = bodyPath
-
-				for _, subReq := range reqs {
-					reqWithDepth := subReq.WithContext(context.WithValue(subReq.Context(), "depth", uint(depth+1)))
-					result.Subs = append(result.Subs, subReq.URL.String())
-					spider.scheduler.Push(reqWithDepth)
+				if noindex {
+					if err = spider.downloader.Remove(urlPath, hdrPath, bodyPath); err != nil {
+						seelog.Errorf("Spider::Run | downloader remove err: %s", err)
+					}
+					result.Noindex = true
+				} else {
+					result.UrlPath = urlPath
+					result.HdrPath = hdrPath
+					result.BodyPath = bodyPath
+				}
+
+				//深度和来源页面以Spider为准
+				recorded := make(map[*Link]bool, len(links))
+				for _, link := range links {
+					recorded[link] = true
+				}
+				for _, sub := range reqs {
+					sub.Depth = cr.Depth + 1
+					sub.Parent = req.URL.String()
+					if sub.Source == "" {
+						sub.Source = SourceLink
+					}
+					//没有实现LinkProcesser的processer只有跟进的链接
+					if sub.Link == nil {
+						sub.Link = &Link{Source: sub.Parent, Target: sub.URL.String(), Followed: true}
+					}
+					if !recorded[sub.Link] {
+						recorded[sub.Link] = true
+						result.Links = append(result.Links, sub.Link)
+					}
+					if !spider.router.schedule(sub) {
+						seelog.Debugf("Spider::Run | %s at depth %d exceeds max depth of route", sub.URL, sub.Depth)
+						sub.Link.filter(LinkReasonMaxDepth)
+						continue
+					}
+					result.Subs = append(result.Subs, sub.URL.String())
+					spider.scheduler.Push(sub)
 				}
 
 			} else {
@@ -357,15 +715,146 @@ func (spider *Spider) Run() *Spider {
 	return spider
 }
 
+//html和xml交给processer, css和javascript分别交给cssProcesser和jsProcesser
+//rss/atom和json只在设置了feedProcesser和jsonProcesser时处理
+func (spider *Spider) defaultRouter() *ProcesserRouter {
+	if spider.processer == nil {
+		spider.processer = NewDomProcesser()
+	}
+	if spider.cssProcesser == nil {
+		spider.cssProcesser = NewCssProcesser()
+	}
+	if spider.jsProcesser == nil {
+		spider.jsProcesser = NewJsProcesser()
+	}
+
+	router := NewProcesserRouter()
+	if spider.extractProcesser != nil {
+		router.Handle([]Processer{spider.extractProcesser},
+			OptionProcesserRouteSuffixs(ContentTypeHTML, ContentTypeHTM, ContentTypeXHTML, ContentTypeXML),
+			OptionProcesserRouteContinue())
+	}
+	router.Handle([]Processer{spider.processer},
+		OptionProcesserRouteSuffixs(ContentTypeHTML, ContentTypeHTM, ContentTypeXHTML, ContentTypeXML))
+	router.Handle([]Processer{spider.cssProcesser},
+		OptionProcesserRouteSuffixs(ContentTypeCSS))
+	router.Handle([]Processer{spider.jsProcesser},
+		OptionProcesserRouteSuffixs(ContentTypeJS, ContentTypeMJS))
+	//条目链接不受同host限制, 没有缺省的feedProcesser
+	if spider.feedProcesser != nil {
+		router.Handle([]Processer{spider.feedProcesser},
+			OptionProcesserRouteSuffixs(ContentTypeRSS, ContentTypeATOM))
+	}
+	//路径表达式因接口而异, 没有缺省的jsonProcesser
+	if spider.jsonProcesser != nil {
+		router.Handle([]Processer{spider.jsonProcesser},
+			OptionProcesserRouteSuffixs(ContentTypeJSON, ContentTypeJSONLD))
+	}
+	return router
+}
+
+//回调在页面之间并发执行, 需要在Run之前注册
+func (spider *Spider) OnResponse(fn func(ctx *CallbackContext)) *Spider {
+	spider.callbacks.OnResponse(fn)
+	return spider
+}
+
+func (spider *Spider) OnHTML(selector string, fn func(e *HTMLElement, ctx *CallbackContext)) *Spider {
+	spider.callbacks.OnHTML(selector, fn)
+	return spider
+}
+
+func (spider *Spider) OnXML(expr string, fn func(e *XMLElement, ctx *CallbackContext)) *Spider {
+	if err := spider.callbacks.OnXML(expr, fn); err != nil {
+		seelog.Errorf("Spider::OnXML | compile %s err: %s", expr, err)
+	}
+	return spider
+}
+
+func (spider *Spider) OnScraped(fn func(ctx *CallbackContext)) *Spider {
+	spider.callbacks.OnScraped(fn)
+	return spider
+}
+
+//作为种子请求加入
 func (spider *Spider) AddRequest(req *http.Request) *Spider {
 	if req == nil {
 		return spider
 	}
-	reqWithDepth := req.WithContext(context.WithValue(req.Context(), "depth", uint(0)))
-	spider.scheduler.Push(reqWithDepth)
+	spider.scheduler.Push(NewCrawlRequest(req))
 	return spider
 }
 
+//保留cr中的深度、优先级和Meta
+func (spider *Spider) AddCrawlRequest(cr *CrawlRequest) *Spider {
+	if cr == nil || cr.Request == nil {
+		return spider
+	}
+	if cr.Meta == nil {
+		cr.Meta = make(map[string]interface{})
+	}
+	spider.scheduler.Push(cr)
+	return spider
+}
+
+//Run退出时结束processer, 关闭pipeline和exporter
+func (spider *Spider) finish() {
+	spider.router.Finish()
+	if spider.pipeline != nil {
+		if err := spider.pipeline.Close(); err != nil {
+			seelog.Errorf("Spider::finish | item pipeline close err: %s", err)
+		}
+	}
+	if spider.exporter != nil {
+		if err := spider.exporter.Close(); err != nil {
+			seelog.Errorf("Spider::finish | result exporter close err: %s", err)
+		}
+	}
+	if spider.linkExporter != nil {
+		if err := spider.linkExporter.Close(); err != nil {
+			seelog.Errorf("Spider::finish | link exporter close err: %s", err)
+		}
+	}
+}
+
+//keepalive模式下队列清空后Run退出
+func (spider *Spider) Stop() {
+	spider.stopOnce.Do(func() {
+		close(spider.stop)
+	})
+}
+
+func (spider *Spider) stopped() bool {
+	select {
+	case <-spider.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+type Stats struct {
+	Results int               `json:"results"`
+	Errors  int               `json:"errors"`
+	Items   ItemPipelineStats `json:"items"`
+}
+
+func (spider *Spider) Stats() Stats {
+	spider.mutex.RLock()
+	stats := Stats{Results: len(spider.results)}
+	for _, result := range spider.results {
+		if result.Error != "" {
+			stats.Errors++
+		}
+	}
+	spider.mutex.RUnlock()
+
+	if spider.pipeline != nil {
+		stats.Items = spider.pipeline.Stats()
+	}
+	return stats
+}
+
 func (spider *Spider) Result() map[string]*Result {
 	spider.mutex.RLock()
 	defer spider.mutex.RUnlock()
@@ -378,7 +867,7 @@ func (spider *Spider) filterCheck(method string, size int64, suffix string) bool
 		if method == "https" && !spider.filter.HttpsAllow() {
 			return false
 		}
-		if !spider.filter.SizeAllow(size) {
+		if !spider.filter.SizeAllow(suffix, size) {
 			return false
 		}
 		if !spider.filter.SuffixAllow(suffix) {
@@ -388,6 +877,42 @@ func (spider *Spider) filterCheck(method string, size int64, suffix string) bool
 	return true
 }
 
+//返回false表示被Filter拒绝
+//服务器不能正确处理HEAD时返回true, 由GET读取预览后判断并提前终止
+func (spider *Spider) preflight(client *http.Client, req *http.Request) bool {
+	if spider.filter == nil || req.Method != http.MethodGet {
+		return true
+	}
+	head, err := http.NewRequestWithContext(req.Context(), http.MethodHead, req.URL.String(), nil)
+	if err != nil {
+		return true
+	}
+	head.Header = req.Header.Clone()
+
+	rsp, err := client.Do(head)
+	if err != nil {
+		seelog.Warnf("Spider::preflight | client do err: %s, fallback to get", err)
+		return true
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode < http.StatusOK || rsp.StatusCode >= http.StatusMultipleChoices {
+		return true
+	}
+
+	suffix, ok := httpHeaderContentType(spider.mimeRegistry, rsp.Header.Get("Content-Type"))
+	if !ok {
+		return true
+	}
+	return spider.filterCheck(req.Method, rsp.ContentLength, suffix)
+}
+
+func (spider *Spider) sizeLimit(suffix string) int64 {
+	if spider.filter == nil {
+		return -1
+	}
+	return spider.filter.SizeLimit(suffix)
+}
+
 func (spider *Spider) exists(url string) bool {
 	spider.mutex.RLock()
 	defer spider.mutex.RUnlock()
@@ -403,6 +928,96 @@ func (spider *Spider) record(url string, result *Result) {
 	spider.results[url] = result
 }
 
+func (spider *Spider) export(result *Result) {
+	if spider.exporter != nil {
+		if err := spider.exporter.Export(result); err != nil {
+			seelog.Errorf("Spider::export | export result err: %s", err)
+		}
+	}
+	if spider.linkExporter != nil {
+		for _, link := range result.Links {
+			if err := spider.linkExporter.Export(link); err != nil {
+				seelog.Errorf("Spider::export | export link err: %s", err)
+			}
+		}
+	}
+}
+
+type hostHealth struct {
+	failures uint      //连续不健康次数
+	probeAt  time.Time //达到阈值后, 此时间之后放行一个探测请求
+}
+
+func (spider *Spider) healthy(host string) bool {
+	spider.hostMutex.Lock()
+	defer spider.hostMutex.Unlock()
+
+	health := spider.hosts[host]
+	if health == nil || health.failures < spider.unhealthyThreshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(health.probeAt) {
+		return false
+	}
+	//放行一个探测请求, 探测结果返回前其他请求继续等待下一次冷却
+	health.probeAt = now.Add(spider.unhealthyCooldown)
+	return true
+}
+
+//不健康状态码累加计数, 其他状态码清零
+func (spider *Spider) checkHealth(host string, code int) {
+	spider.hostMutex.Lock()
+	defer spider.hostMutex.Unlock()
+
+	if !spider.statusPolicy.Unhealthy(code) {
+		delete(spider.hosts, host)
+		return
+	}
+	health := spider.hosts[host]
+	if health == nil {
+		health = &hostHealth{}
+		spider.hosts[host] = health
+	}
+	health.failures++
+	if health.failures == spider.unhealthyThreshold {
+		health.probeAt = time.Now().Add(spider.unhealthyCooldown)
+		seelog.Warnf("Spider::checkHealth | host: %s marked unhealthy, last status code: %d", host, code)
+	}
+}
+
+//Retry-After可以是秒数或者http日期
+func (spider *Spider) retryWait(rsp *http.Response, retry uint) time.Duration {
+	delay := spider.retryDelay
+	for i := uint(0); i < retry && delay < RetryDelayMax; i++ {
+		delay *= 2
+	}
+	if after := rsp.Header.Get("Retry-After"); after != "" {
+		if seconds, err := strconv.Atoi(after); err == nil {
+			delay = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(after); err == nil {
+			delay = time.Until(date)
+		}
+	}
+	if delay > RetryDelayMax {
+		delay = RetryDelayMax
+	}
+	return delay
+}
+
+//Stop之后不再等待
+func (spider *Spider) wait(delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-spider.stop:
+	}
+}
+
 func (spider *Spider) sleep() {
 	switch spider.sleepType {
 	case SleepTypeNode:
@@ -421,6 +1036,49 @@ func (spider *Spider) sleep() {
 	return
 }
 
+//读取超过limit后返回io.EOF并标记exceeded, limit为负数时不限制
+type limitedReader struct {
+	reader   io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.limit >= 0 {
+		if lr.read >= lr.limit {
+			//多读一个字节确认是否真的超出
+			one := make([]byte, 1)
+			if n, _ := io.ReadFull(lr.reader, one); n > 0 {
+				lr.exceeded = true
+			}
+			return 0, io.EOF
+		}
+		if int64(len(p)) > lr.limit-lr.read {
+			p = p[:lr.limit-lr.read]
+		}
+	}
+	n, err := lr.reader.Read(p)
+	lr.read += int64(n)
+	return n, err
+}
+
+//GET和HEAD以url为key, 其他方法的key为"方法 url body摘要", 同一个url的不同表单提交不被去重
+func requestKey(req *http.Request) string {
+	key := req.URL.String()
+	if req.Method == "" || req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return key
+	}
+	digest := md5.New()
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			io.Copy(digest, body)
+			body.Close()
+		}
+	}
+	return req.Method + " " + key + " " + hex.EncodeToString(digest.Sum(nil))
+}
+
 func httpResponseChunked(transferEncoding []string) bool {
 	for _, encoding := range transferEncoding {
 		if encoding == "chunked" {
@@ -431,24 +1089,25 @@ func httpResponseChunked(transferEncoding []string) bool {
 }
 
 //https://tools.ietf.org/html/rfc2045 #5.1
-func httpResponseContentType(data []byte, contentType string) (string, error) {
-	arbitrate := func(data []byte) string {
-		return http.DetectContentType(data)
+//返回后缀、声明的类型和嗅探的类型
+func httpResponseContentType(registry *MimeRegistry, policy uint, data []byte, contentType string) (string, string, string, error) {
+	declared := declaredContentType(contentType)
+	detected := detectContentType(data)
+
+	v, ok := registry.Extension(sniffContentType(registry, policy, declared, detected))
+	if !ok {
+		return "", declared, detected, errors.New("unsupported content-type")
 	}
+	return v, declared, detected, nil
+}
 
+//仅根据Content-Type头判断类型, 无法判断时返回false
+func httpHeaderContentType(registry *MimeRegistry, contentType string) (string, bool) {
 	mediaType, _, err := mime.ParseMediaType(contentType)
-	if err != nil || !strings.Contains(mediaType, "/") {
-		return "", errors.New("unsupported content-type")
-	}
-	v, ok := ContentTypes[mediaType]
-	if !ok {
-		mediaType = arbitrate(data)
-		v, ok = ContentTypes[mediaType]
-		if !ok {
-			return "", errors.New("unsupported content-type")
-		}
+	if err != nil {
+		return "", false
 	}
-	return v, nil
+	return registry.Extension(mediaType)
 }
 
 func httpResponseCharset(data []byte, contentType string) (string, bool) {
@@ -2,8 +2,15 @@ package spider
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const (
@@ -24,3 +31,266 @@ func Test_Spider(t *testing.T) {
 	data, _ := json.Marshal(result)
 	t.Log(string(data))
 }
+
+//go test -v -run=Test_SpiderStatusCode
+func Test_SpiderStatusCode(t *testing.T) {
+	var unavailable int32
+	busy := []time.Time{}
+	mutex := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/missing">missing</a><a href="/busy">busy</a><a href="/later">later</a></body></html>`)
+		case "/busy":
+			atomic.AddInt32(&unavailable, 1)
+			mutex.Lock()
+			busy = append(busy, time.Now())
+			mutex.Unlock()
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/later":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<html><body><a href="/error-page-link">home</a></body></html>`)
+		}
+	}))
+	defer server.Close()
+
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	exporter := &countExporter{records: map[string]int{}}
+	delay := 50 * time.Millisecond
+	result := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+		OptionSpiderRetryDelay(delay),
+		OptionSpiderResultExporter(exporter),
+	).AddRequest(request).Run().Result()
+
+	missing := result[server.URL+"/missing"]
+	if missing == nil || missing.StatusCode != http.StatusNotFound || missing.BodyPath != nil || len(missing.Subs) != 0 {
+		t.Errorf("404 page should be neither downloaded nor parsed: %+v", missing)
+	}
+	busyResult := result[server.URL+"/busy"]
+	if busyResult == nil || busyResult.Retry != RetryMaxDefault || atomic.LoadInt32(&unavailable) != RetryMaxDefault+1 {
+		t.Errorf("503 page should be retried %d times, got %d requests", RetryMaxDefault, atomic.LoadInt32(&unavailable))
+	}
+	mutex.Lock()
+	for i := 1; i < len(busy); i++ {
+		//第i次重试前等待delay*2^(i-1)
+		if gap := busy[i].Sub(busy[i-1]); gap < delay<<uint(i-1) {
+			t.Errorf("retry %d should wait at least %s, waited %s", i, delay<<uint(i-1), gap)
+		}
+	}
+	mutex.Unlock()
+	if later := result[server.URL+"/later"]; later == nil || later.Retry != RetryMaxDefault {
+		t.Errorf("429 page should be retried after Retry-After: %+v", later)
+	}
+	for _, path := range []string{"/busy", "/later"} {
+		if n := exporter.count(server.URL + path); n != 1 {
+			t.Errorf("%s should be exported once, got %d", path, n)
+		}
+	}
+}
+
+//按url计数
+type countExporter struct {
+	records map[string]int
+	closed  bool
+	mutex   sync.Mutex
+}
+
+func (ce *countExporter) Export(record interface{}) error {
+	if result, ok := record.(*Result); ok {
+		ce.mutex.Lock()
+		ce.records[result.Req.URL.String()]++
+		ce.mutex.Unlock()
+	}
+	return nil
+}
+
+func (ce *countExporter) Close() error {
+	ce.mutex.Lock()
+	ce.closed = true
+	ce.mutex.Unlock()
+	return nil
+}
+
+func (ce *countExporter) count(url string) int {
+	ce.mutex.Lock()
+	defer ce.mutex.Unlock()
+	return ce.records[url]
+}
+
+//go test -v -run=Test_SpiderUnhealthyCooldown
+func Test_SpiderUnhealthyCooldown(t *testing.T) {
+	cooldown := 20 * time.Millisecond
+	spider := NewSpider(OptionSpiderUnhealthyThreshold(2), OptionSpiderUnhealthyCooldown(cooldown))
+	host := "example.com"
+
+	spider.checkHealth(host, http.StatusServiceUnavailable)
+	if !spider.healthy(host) {
+		t.Error("host should stay healthy below threshold")
+	}
+	spider.checkHealth(host, http.StatusServiceUnavailable)
+	if spider.healthy(host) {
+		t.Error("host should be unhealthy at threshold")
+	}
+
+	time.Sleep(cooldown + 10*time.Millisecond)
+	if !spider.healthy(host) {
+		t.Error("a probe should be allowed after cooldown")
+	}
+	if spider.healthy(host) {
+		t.Error("only one probe should be allowed per cooldown")
+	}
+	//探测失败, 重新冷却
+	spider.checkHealth(host, http.StatusServiceUnavailable)
+	if spider.healthy(host) {
+		t.Error("host should stay unhealthy after a failed probe")
+	}
+
+	time.Sleep(cooldown + 10*time.Millisecond)
+	if !spider.healthy(host) {
+		t.Error("a probe should be allowed after cooldown")
+	}
+	spider.checkHealth(host, http.StatusOK)
+	if !spider.healthy(host) || !spider.healthy(host) {
+		t.Error("host should recover after a successful probe")
+	}
+}
+
+//go test -v -run=Test_SpiderSizeLimit
+func Test_SpiderSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		//分块写入并flush, 使响应为chunked
+		for i := 0; i < 64; i++ {
+			fmt.Fprint(w, strings.Repeat("x", 1024))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	filter, err := NewLimitFilter(
+		OptionFilterSuffixs([]string{ContentTypeTXT}),
+		OptionFilterSuffixSize(ContentTypeTXT, 4096))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	//未设置大小的filter不限制
+	unlimited, err := NewLimitFilter(OptionFilterSuffixs([]string{ContentTypeTXT}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/big.txt", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	full := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderFilter(unlimited),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+	).AddRequest(request).Run().Result()[server.URL+"/big.txt"]
+	if full == nil || full.Truncated || full.BodyPath == nil {
+		t.Errorf("filter without size should not limit the body: %+v", full)
+	} else if info, err := os.Stat(*full.BodyPath); err != nil || info.Size() != 64*1024 {
+		t.Errorf("body should be %d bytes: %v, %v", 64*1024, info, err)
+	}
+
+	for _, truncatedAllowed := range []bool{false, true} {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/big.txt", nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		result := NewSpider(
+			OptionSpiderSleep(SleepTypeNode, 0, 1),
+			OptionSpiderFilter(filter),
+			OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+			OptionSpiderResponseTruncatedAllowed(truncatedAllowed),
+		).AddRequest(request).Run().Result()[server.URL+"/big.txt"]
+
+		if !truncatedAllowed {
+			if result.Error == "" || result.BodyPath != nil {
+				t.Errorf("oversized chunked response should be rejected: %+v", result)
+			}
+			continue
+		}
+		if !result.Truncated || result.BodyPath == nil {
+			t.Errorf("oversized chunked response should be truncated: %+v", result)
+			continue
+		}
+		info, err := os.Stat(*result.BodyPath)
+		if err != nil || info.Size() != 4096 {
+			t.Errorf("truncated body should be 4096 bytes: %v, %v", info, err)
+		}
+	}
+}
+
+//go test -v -run=Test_SpiderHeadPreflight
+func Test_SpiderHeadPreflight(t *testing.T) {
+	gets := map[string]int{}
+	mutex := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mutex.Lock()
+			gets[r.URL.Path]++
+			mutex.Unlock()
+		}
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><body><img src="/big.png"><img src="/nohead.png"></body></html>`)
+		case "/nohead.png":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			fallthrough
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Length", "8192")
+			w.Write(make([]byte, 8192))
+		}
+	}))
+	defer server.Close()
+
+	filter, err := NewLimitFilter(
+		OptionFilterSuffixs([]string{ContentTypeHTML, ContentTypePNG}),
+		OptionFilterSize(1024))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	result := NewSpider(
+		OptionSpiderSleep(SleepTypeNode, 0, 1),
+		OptionSpiderFilter(filter),
+		OptionSpiderHeadPreflight(true),
+		OptionSpiderDownloader(NewFileDownloader(t.TempDir())),
+	).AddRequest(request).Run().Result()
+
+	mutex.Lock()
+	big, nohead := gets["/big.png"], gets["/nohead.png"]
+	mutex.Unlock()
+	if big != 0 {
+		t.Errorf("oversized png should be rejected before get")
+	}
+	if nohead != 1 || result[server.URL+"/nohead.png"].Error == "" {
+		t.Errorf("server without head should fall back to get and be rejected")
+	}
+}
@@ -0,0 +1,105 @@
+package spider
+
+//决定不同状态码的响应是否下载、解析、重试以及是否标记host不健康
+type StatusPolicy interface {
+	DownloadAllow(code int) bool
+	ProcessAllow(code int) bool
+	RetryAllow(code int) bool
+	Unhealthy(code int) bool
+}
+
+//闭区间[Min, Max]
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+func StatusCode(code int) StatusRange {
+	return StatusRange{Min: code, Max: code}
+}
+
+//class为状态码首位, 比如2代表2xx
+func StatusClass(class int) StatusRange {
+	return StatusRange{Min: class * 100, Max: class*100 + 99}
+}
+
+func (sr StatusRange) contains(code int) bool {
+	return code >= sr.Min && code <= sr.Max
+}
+
+type OptionStatusPolicy func(*RangeStatusPolicy)
+
+func OptionStatusPolicyDownload(ranges ...StatusRange) OptionStatusPolicy {
+	return func(sp *RangeStatusPolicy) {
+		sp.download = ranges
+	}
+}
+
+func OptionStatusPolicyProcess(ranges ...StatusRange) OptionStatusPolicy {
+	return func(sp *RangeStatusPolicy) {
+		sp.process = ranges
+	}
+}
+
+func OptionStatusPolicyRetry(ranges ...StatusRange) OptionStatusPolicy {
+	return func(sp *RangeStatusPolicy) {
+		sp.retry = ranges
+	}
+}
+
+func OptionStatusPolicyUnhealthy(ranges ...StatusRange) OptionStatusPolicy {
+	return func(sp *RangeStatusPolicy) {
+		sp.unhealthy = ranges
+	}
+}
+
+type RangeStatusPolicy struct {
+	download  []StatusRange
+	process   []StatusRange
+	retry     []StatusRange
+	unhealthy []StatusRange
+}
+
+//默认仅下载和解析2xx, 超时、限流和网关类错误重试, 5xx标记不健康
+func NewRangeStatusPolicy(options ...OptionStatusPolicy) *RangeStatusPolicy {
+	sp := &RangeStatusPolicy{
+		download: []StatusRange{StatusClass(2)},
+		process:  []StatusRange{StatusClass(2)},
+		retry: []StatusRange{
+			StatusCode(408),
+			StatusCode(429),
+			StatusCode(500),
+			{Min: 502, Max: 504},
+		},
+		unhealthy: []StatusRange{StatusClass(5)},
+	}
+	for _, option := range options {
+		option(sp)
+	}
+	return sp
+}
+
+func (sp *RangeStatusPolicy) DownloadAllow(code int) bool {
+	return statusIn(code, sp.download)
+}
+
+func (sp *RangeStatusPolicy) ProcessAllow(code int) bool {
+	return statusIn(code, sp.process)
+}
+
+func (sp *RangeStatusPolicy) RetryAllow(code int) bool {
+	return statusIn(code, sp.retry)
+}
+
+func (sp *RangeStatusPolicy) Unhealthy(code int) bool {
+	return statusIn(code, sp.unhealthy)
+}
+
+func statusIn(code int, ranges []StatusRange) bool {
+	for _, sr := range ranges {
+		if sr.contains(code) {
+			return true
+		}
+	}
+	return false
+}
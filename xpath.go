@@ -0,0 +1,101 @@
+package spider
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+)
+
+//XPath 1.0, html文档在goquery的节点上求值, xml文档用xmlquery解析, 二者都通过xpath.NodeNavigator访问
+
+var xmlDeclEncoding = regexp.MustCompile(`^(\s*<\?xml[^>]*?encoding\s*=\s*)["'][^"']*["']`)
+
+//按Spider嗅探的后缀判断, xhtml仍然按html处理, 与浏览器的容错行为一致
+func xmlDocument(rsp *http.Response, body []byte) bool {
+	suffix := ResponseSuffix(rsp)
+	if suffix == "" {
+		return bytes.HasPrefix(bytes.TrimSpace(body), []byte("<?xml")) &&
+			!bytes.Contains(bytes.ToLower(body), []byte("<html"))
+	}
+	mediaType, _ := DefaultMimeRegistry.MediaType(suffix)
+	return strings.Contains(mediaType, "xml") && !strings.Contains(mediaType, "html")
+}
+
+//body已经转换为utf-8, 声明中的encoding需要同步修改, 否则xmlquery会再转换一次
+func parseXml(body []byte) (xpath.NodeNavigator, error) {
+	body = xmlDeclEncoding.ReplaceAll(body, []byte(`${1}"UTF-8"`))
+	doc, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return xmlquery.CreateXPathNavigator(doc), nil
+}
+
+//xml文档解析失败时退回html的结果
+func xpathRoot(rsp *http.Response, body []byte, doc *goquery.Document) xpath.NodeNavigator {
+	if xmlDocument(rsp, body) {
+		if nav, err := parseXml(body); err == nil {
+			return nav
+		}
+	}
+	return htmlquery.CreateXPathNavigator(doc.Nodes[0])
+}
+
+//节点集中的每个节点: 属性取值, 元素取文本; string()、count()等表达式直接取结果
+func xpathValues(nav xpath.NodeNavigator, expr *xpath.Expr) []string {
+	return xpathEach(nav, expr, func(node xpath.NodeNavigator) []string {
+		return []string{node.Value()}
+	})
+}
+
+//节点集中的每个节点由value取值
+func xpathEach(nav xpath.NodeNavigator, expr *xpath.Expr, value func(xpath.NodeNavigator) []string) []string {
+	switch result := expr.Evaluate(nav.Copy()).(type) {
+	case *xpath.NodeIterator:
+		var values []string
+		for result.MoveNext() {
+			values = append(values, value(result.Current())...)
+		}
+		return values
+	case string:
+		return []string{result}
+	case float64:
+		return []string{strconv.FormatFloat(result, 'f', -1, 64)}
+	case bool:
+		return []string{strconv.FormatBool(result)}
+	}
+	return nil
+}
+
+//xpath.Expr求值时会修改内部状态, 不能在并发的页面之间共享
+//每个页面使用自己的xpathExprs, 同一个表达式在页面内只编译一次
+type xpathExprs map[string]*xpath.Expr
+
+func (exprs xpathExprs) compile(expr string) (*xpath.Expr, error) {
+	if compiled, ok := exprs[expr]; ok {
+		return compiled, nil
+	}
+	compiled, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	exprs[expr] = compiled
+	return compiled, nil
+}
+
+func xpathAttr(nav xpath.NodeNavigator, name string) (string, bool) {
+	nav = nav.Copy()
+	for nav.MoveToNextAttribute() {
+		if nav.LocalName() == name {
+			return nav.Value(), true
+		}
+	}
+	return "", false
+}
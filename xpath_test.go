@@ -0,0 +1,108 @@
+package spider
+
+import (
+	"reflect"
+	"testing"
+)
+
+//go test -v -run=Test_DomProcesserXPathRules
+func Test_DomProcesserXPathRules(t *testing.T) {
+	page := `<html><body>
+<div class="nav"><a href="/a">a</a><a href="/b" rel="nofollow">b</a></div>
+<a href="/c">c</a>
+<span data-page="/d"></span>
+</body></html>`
+	rsp := newTestResponse(t, "http://example.com/", "text/html", []byte(page))
+	reqs, err := NewDomProcesser(OptionDomProcesserRules(
+		LinkRule{XPath: "//div[@class='nav']/a", Attrs: []string{"href"}},
+		LinkRule{XPath: "//span/@data-page"},
+		LinkRule{XPath: "concat('/', 'e')"},
+	)).Process("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var links []string
+	for _, req := range reqs {
+		links = append(links, req.URL.String())
+	}
+	expected := []string{"http://example.com/a", "http://example.com/d", "http://example.com/e"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("expected %v, got %v", expected, links)
+	}
+}
+
+//go test -v -run=Test_XPathXmlDocument
+func Test_XPathXmlDocument(t *testing.T) {
+	//ISO-8859-1编码, 转换为utf-8之后声明中的encoding不能再次生效
+	doc := `<?xml version="1.0" encoding="ISO-8859-1"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>http://example.com/caf%C3%A9</loc><title>caf` + "\xe9" + `</title></url>
+<url><loc>http://example.com/b</loc><title>b</title></url>
+</urlset>`
+
+	rsp := newTestResponse(t, "http://example.com/", "application/xml", []byte(doc))
+	reqs, err := NewDomProcesser(OptionDomProcesserRules(LinkRule{XPath: "//url/loc"})).Process("iso-8859-1", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(reqs) != 2 || reqs[1].URL.String() != "http://example.com/b" {
+		t.Errorf("unexpected requests %v", reqs)
+	}
+
+	var titles []string
+	cp := NewCallbackProcesser()
+	if err := cp.OnXML("//url", func(e *XMLElement, ctx *CallbackContext) {
+		titles = append(titles, e.ChildText("title"))
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	rsp = newTestResponse(t, "http://example.com/", "text/xml", []byte(doc))
+	if _, err := cp.Process("iso-8859-1", true, rsp); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(titles, []string{"café", "b"}) {
+		t.Errorf("unexpected titles %q", titles)
+	}
+}
+
+//go test -v -run=Test_ExtractXmlDocument
+func Test_ExtractXmlDocument(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel><myTitle>news</myTitle>
+<item><title>first</title><link>http://example.com/1</link><category term="a"/></item>
+<item><title>second</title><link>http://example.com/2</link><category term="b"/></item>
+</channel></rss>`
+	ep, err := NewExtractProcesser([]*ExtractRule{
+		{Fields: []Field{
+			{Name: "channel", XPath: "//channel/myTitle"},
+			{Name: "links", XPath: "//item/link", All: true},
+		}},
+		{ScopeXPath: "//item", Fields: []Field{
+			{Name: "title", XPath: "title"},
+			{Name: "link", XPath: "link", Type: FieldTypeUrl},
+			{Name: "category", XPath: "category", Attr: "term"},
+		}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rsp := newTestResponse(t, "http://example.com/feed", "application/xml", []byte(feed))
+	_, items, err := ep.ProcessItems("utf-8", true, rsp)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := []Item{
+		{"channel": "news", "links": []string{"http://example.com/1", "http://example.com/2"}},
+		{"title": "first", "link": "http://example.com/1", "category": "a"},
+		{"title": "second", "link": "http://example.com/2", "category": "b"},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("expected %v, got %v", expected, items)
+	}
+}